@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"todo_app/app/models"
+	"todo_app/config"
+)
+
+// testConfig points the database and attachments at the scratch directory
+// and keeps password hashing cheap.
+const testConfig = `[db]
+driver = sqlite3
+name = %[1]s
+
+[attachments]
+dir = %[2]s
+
+[password]
+argon2_memory = 8192
+argon2_threads = 1
+`
+
+// TestMain runs the package's tests against a fresh database in a
+// temporary directory, which is removed afterwards.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "todo_app_test")
+	if err != nil {
+		fmt.Println("Creating test directory failed:", err)
+		os.Exit(1)
+	}
+	os.Exit(runTests(m, dir))
+}
+
+func runTests(m *testing.M, dir string) int {
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.ini")
+	settings := fmt.Sprintf(testConfig, filepath.Join(dir, "test.sql"), filepath.Join(dir, "attachments"))
+	if err := os.WriteFile(path, []byte(settings), 0o600); err != nil {
+		fmt.Println("Writing test config failed:", err)
+		return 1
+	}
+	config.Load(path)
+	models.ConfigurePasswordHasher(config.Config.Argon2Time, config.Config.Argon2Memory, config.Config.Argon2Threads)
+	if err := models.Open(); err != nil {
+		return 1
+	}
+	defer models.Db.Close()
+	if _, err := models.MigrateUp(false); err != nil {
+		fmt.Println("Migration failed:", err)
+		return 1
+	}
+	return m.Run()
+}
+
+var testUsers atomic.Int64
+
+// newTestEmail returns an address no test has used yet.
+func newTestEmail() string {
+	return fmt.Sprintf("user%d@example.com", testUsers.Add(1))
+}
+
+// testClient is a signed-in user sending requests straight to the router.
+type testClient struct {
+	t      *testing.T
+	router http.Handler
+	user   models.User
+	cookie *http.Cookie
+}
+
+// newTestClient signs up a user with a unique email address through the
+// router and signs them in.
+func newTestClient(t *testing.T, router http.Handler) *testClient {
+	t.Helper()
+	c := &testClient{t: t, router: router, cookie: &http.Cookie{Name: "_cookie"}}
+	credentials := fmt.Sprintf(`{"name": "user", "email": %q, "password": "password1"}`, newTestEmail())
+	if w := c.do(http.MethodPost, "/signup", credentials); w.Code != http.StatusOK {
+		t.Fatalf("signup status = %d; body %s", w.Code, w.Body)
+	}
+	w := c.do(http.MethodPost, "/authenticate", credentials)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == c.cookie.Name {
+			c.cookie = cookie
+		}
+	}
+	if w.Code != http.StatusOK || c.cookie.Value == "" {
+		t.Fatalf("authenticate status = %d; body %s", w.Code, w.Body)
+	}
+	sess := models.Session{UUID: c.cookie.Value}
+	if ok, err := sess.CheckSession(); !ok {
+		t.Fatalf("CheckSession: %v", err)
+	}
+	user, err := sess.GetUserBySession()
+	if err != nil {
+		t.Fatalf("GetUserBySession: %v", err)
+	}
+	c.user = user
+	return c
+}
+
+func (c *testClient) do(method, path, body string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.AddCookie(c.cookie)
+	w := httptest.NewRecorder()
+	c.router.ServeHTTP(w, r)
+	return w
+}
+
+func (c *testClient) createTodo(content string) models.Todo {
+	c.t.Helper()
+	todo, err := c.user.CreateTodo(models.Todo{Content: content})
+	if err != nil {
+		c.t.Fatalf("CreateTodo: %v", err)
+	}
+	return todo
+}
+
+func (c *testClient) createWorkspace() models.Workspace {
+	c.t.Helper()
+	ws, err := c.user.CreateWorkspace("Team")
+	if err != nil {
+		c.t.Fatalf("CreateWorkspace: %v", err)
+	}
+	return ws
+}
+
+// joinWorkspace invites c into the workspace with the role and accepts.
+func (c *testClient) joinWorkspace(owner *testClient, workspaceID int, role models.Role) {
+	c.t.Helper()
+	invitation, err := owner.user.InviteMember(workspaceID, c.user.Email, role)
+	if err != nil {
+		c.t.Fatalf("InviteMember: %v", err)
+	}
+	if _, err = c.user.RespondToInvitation(invitation.ID, true); err != nil {
+		c.t.Fatalf("RespondToInvitation: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"todo_app/app/models"
+)
+
+// apiError is the body of every error response:
+//
+//	{"status":"error","error":{"code":"validation_failed","message":"...","fields":{"priority":"..."}}}
+type apiError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// errorCode gives the machine-readable code for a status, derived from its
+// standard text ("Not Found" -> "not_found").
+func errorCode(status int) string {
+	switch status {
+	case http.StatusUnprocessableEntity:
+		return "validation_failed"
+	case http.StatusInternalServerError:
+		return "internal_error"
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeErrorFields(w, status, message, nil)
+}
+
+func writeErrorFields(w http.ResponseWriter, status int, message string, fields map[string]string) {
+	writeJSON(w, status, map[string]interface{}{
+		"status": "error",
+		"error": apiError{
+			Code:    errorCode(status),
+			Message: message,
+			Fields:  fields,
+		},
+	})
+}
+
+// writeModelError maps errors from the models package: validation failures
+// become 422, sql.ErrNoRows becomes a 404 with notFound as the message, a
+// workspace role that is too low a 403, and anything else is logged and
+// reported as 500.
+func writeModelError(w http.ResponseWriter, err error, notFound string) {
+	var verr *models.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		writeErrorFields(w, http.StatusUnprocessableEntity, "Validation failed", verr.Fields)
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, notFound)
+	case errors.Is(err, models.ErrForbidden):
+		writeError(w, http.StatusForbidden, "Your role in this workspace does not allow this")
+	default:
+		log.Printf("Model error: %v", err)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
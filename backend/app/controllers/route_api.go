@@ -0,0 +1,523 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"todo_app/app/models"
+)
+
+// todoRequest is the body accepted by POST, PUT and PATCH on /api/v1/todos.
+// WorkspaceID is only read on create, where 0 means the personal space;
+// todos never change workspace.
+type todoRequest struct {
+	WorkspaceID int             `json:"workspaceId"`
+	Content     string          `json:"content"`
+	Description string          `json:"description"`
+	Priority    models.Priority `json:"priority"`
+	Status      models.Status   `json:"status"`
+	DueDate     string          `json:"dueDate"`
+	DueTime     string          `json:"dueTime"`
+	ProjectID   *int            `json:"projectId"`
+	ParentID    *int            `json:"parentId"`
+	Recurrence  string          `json:"recurrence"`
+}
+
+func (req todoRequest) todo() models.Todo {
+	return models.Todo{
+		Content:     req.Content,
+		Description: req.Description,
+		Priority:    req.Priority,
+		Status:      req.Status,
+		DueDate:     req.DueDate,
+		DueTime:     req.DueTime,
+		ProjectID:   req.ProjectID,
+		ParentID:    req.ParentID,
+		Recurrence:  req.Recurrence,
+	}
+}
+
+func decodeTodoRequest(w http.ResponseWriter, r *http.Request) (req todoRequest, ok bool) {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("JSON decode error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return req, false
+	}
+	return req, true
+}
+
+// todoPatchRequest distinguishes omitted fields (nil) from supplied ones.
+type todoPatchRequest struct {
+	Content     *string          `json:"content"`
+	Description *string          `json:"description"`
+	Priority    *models.Priority `json:"priority"`
+	Status      *models.Status   `json:"status"`
+	DueDate     *string          `json:"dueDate"`
+	DueTime     *string          `json:"dueTime"`
+	ProjectID   *int             `json:"projectId"`
+	ParentID    *int             `json:"parentId"`
+	Recurrence  *string          `json:"recurrence"`
+}
+
+const mergePatchContentType = "application/merge-patch+json"
+
+func decodeTodoPatch(w http.ResponseWriter, r *http.Request) (patch models.TodoPatch, ok bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Body read error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return patch, false
+	}
+	var req todoPatchRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		log.Printf("JSON unmarshal error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return patch, false
+	}
+	patch = models.TodoPatch{
+		Content:     req.Content,
+		Description: req.Description,
+		Priority:    req.Priority,
+		Status:      req.Status,
+		DueDate:     req.DueDate,
+		DueTime:     req.DueTime,
+		ProjectID:   req.ProjectID,
+		ParentID:    req.ParentID,
+		Recurrence:  req.Recurrence,
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == mergePatchContentType {
+		// In a merge patch null means "remove", which for todo fields is
+		// a reset to the value a new todo would get. Content has no such
+		// value, so it cannot be removed.
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			log.Printf("JSON unmarshal error on %s %s: %v", r.Method, r.URL.Path, err)
+			writeError(w, http.StatusBadRequest, "Invalid JSON")
+			return patch, false
+		}
+		isNull := func(key string) bool {
+			raw, ok := fields[key]
+			return ok && string(raw) == "null"
+		}
+		if isNull("content") {
+			writeErrorFields(w, http.StatusUnprocessableEntity, "Validation failed", map[string]string{"content": "must not be null"})
+			return patch, false
+		}
+		empty := ""
+		if isNull("description") {
+			patch.Description = &empty
+		}
+		if isNull("recurrence") {
+			patch.Recurrence = &empty
+		}
+		if isNull("priority") {
+			priority := models.PriorityMedium
+			patch.Priority = &priority
+		}
+		if isNull("status") {
+			status := models.Status("") // the first column of the workflow
+			patch.Status = &status
+		}
+		if isNull("dueDate") {
+			patch.DueDate = &empty
+		}
+		if isNull("dueTime") {
+			patch.DueTime = &empty
+		}
+		if isNull("projectId") {
+			noProject := 0
+			patch.ProjectID = &noProject
+		}
+		if isNull("parentId") {
+			noParent := 0
+			patch.ParentID = &noParent
+		}
+	}
+	return patch, true
+}
+
+// parseTodoQuery reads the listing parameters shared by /todos and
+// /api/v1/todos:
+//
+//	status=todo,in_progress  priority=high  due_from=2024-01-01  due_to=2024-01-31
+//	overdue=true  today=true  q=text  project_id=3|none  tags=1,4  tag_mode=any|all|none
+//	completed_from=2024-01-01  completed_to=2024-01-07  updated_since=2024-01-01T09:00:00Z
+//	flat=true  sort=-due_date  limit=50  cursor=<next_cursor>
+func parseTodoQuery(r *http.Request) (q models.TodoQuery, err error) {
+	v := r.URL.Query()
+	verr := &models.ValidationError{}
+
+	for _, s := range splitList(v.Get("status")) {
+		q.Statuses = append(q.Statuses, models.Status(s))
+	}
+	for _, p := range splitList(v.Get("priority")) {
+		q.Priorities = append(q.Priorities, models.Priority(p))
+	}
+	q.DueFrom = v.Get("due_from")
+	q.DueTo = v.Get("due_to")
+	q.CompletedFrom = v.Get("completed_from")
+	q.CompletedTo = v.Get("completed_to")
+	if s := v.Get("updated_since"); s != "" {
+		since, parseErr := time.Parse(time.RFC3339, s)
+		if parseErr != nil {
+			verr.Add("updated_since", "must be an RFC 3339 timestamp")
+		} else {
+			q.UpdatedSince = &since
+		}
+	}
+	q.Search = strings.TrimSpace(v.Get("q"))
+	q.Cursor = v.Get("cursor")
+
+	switch s := v.Get("project_id"); s {
+	case "":
+	case "none":
+		noProject := 0
+		q.ProjectID = &noProject
+	default:
+		id, convErr := strconv.Atoi(s)
+		if convErr != nil || id < 1 {
+			verr.Add("project_id", `must be a project id or "none"`)
+		} else {
+			q.ProjectID = &id
+		}
+	}
+
+	for _, s := range splitList(v.Get("tags")) {
+		id, convErr := strconv.Atoi(s)
+		if convErr != nil || id < 1 {
+			verr.Add("tags", "must be a comma-separated list of tag ids")
+			continue
+		}
+		q.Tags = append(q.Tags, id)
+	}
+	q.TagMode = models.TagMode(v.Get("tag_mode"))
+
+	if s := v.Get("overdue"); s != "" {
+		if q.Overdue, err = strconv.ParseBool(s); err != nil {
+			verr.Add("overdue", "must be true or false")
+		}
+	}
+	if s := v.Get("today"); s != "" {
+		if q.DueToday, err = strconv.ParseBool(s); err != nil {
+			verr.Add("today", "must be true or false")
+		}
+	}
+	if s := v.Get("flat"); s != "" {
+		if q.Flat, err = strconv.ParseBool(s); err != nil {
+			verr.Add("flat", "must be true or false")
+		}
+	}
+	if s := v.Get("sort"); s != "" {
+		q.Sort, q.Desc = strings.TrimPrefix(s, "-"), strings.HasPrefix(s, "-")
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 1 {
+			verr.Add("limit", "must be a positive integer")
+		}
+	}
+	return q, verr.Err()
+}
+
+// cascadeRequested reports whether ?cascade=true asked for completing or
+// deleting a todo to apply to its subtasks as well.
+func cascadeRequested(r *http.Request) bool {
+	cascade, _ := strconv.ParseBool(r.URL.Query().Get("cascade"))
+	return cascade
+}
+
+// cascadeCompletion completes the subtasks of a todo just moved into a done
+// column when the client asked for it and returns the todo as it is now
+// stored.
+func cascadeCompletion(r *http.Request, user models.User, todo models.Todo) (models.Todo, error) {
+	if !cascadeRequested(r) || !todo.Done || len(todo.Subtasks) == 0 {
+		return todo, nil
+	}
+	return user.CompleteSubtasks(todo.ID)
+}
+
+// renderRequested reports whether the client asked for HTML renderings of
+// Markdown fields with ?render=html.
+func renderRequested(r *http.Request) bool {
+	return r.URL.Query().Get("render") == "html"
+}
+
+// prepareTodos makes todos ready for encoding: never null, and rendered
+// when the client asked for HTML.
+func prepareTodos(r *http.Request, todos []models.Todo) []models.Todo {
+	if todos == nil {
+		return []models.Todo{}
+	}
+	if renderRequested(r) {
+		for i := range todos {
+			todos[i].RenderDescription()
+		}
+	}
+	return todos
+}
+
+func prepareTodo(r *http.Request, todo models.Todo) models.Todo {
+	if renderRequested(r) {
+		todo.RenderDescription()
+	}
+	return todo
+}
+
+func splitList(s string) (items []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// checkTodoQueryRefs answers with a 404 naming the project or tag when a
+// filter refers to one the user cannot see, rather than listing nothing.
+func checkTodoQueryRefs(w http.ResponseWriter, user models.User, q models.TodoQuery) bool {
+	if q.ProjectID != nil && *q.ProjectID != 0 {
+		if _, err := user.GetProject(*q.ProjectID); err != nil {
+			writeModelError(w, err, "Project not found")
+			return false
+		}
+	}
+	for _, id := range q.Tags {
+		if _, err := user.GetTag(id); err != nil {
+			writeModelError(w, err, "Tag not found")
+			return false
+		}
+	}
+	return true
+}
+
+func apiTodoList(w http.ResponseWriter, r *http.Request) {
+	user, ok := workspaceScope(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+	q, err := parseTodoQuery(r)
+	if err != nil {
+		writeModelError(w, err, "Not found")
+		return
+	}
+	if !checkTodoQueryRefs(w, user, q) {
+		return
+	}
+
+	todos, nextCursor, err := user.ListTodos(q)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":      "success",
+		"todos":       prepareTodos(r, todos),
+		"next_cursor": nextCursor,
+	})
+}
+
+func apiTodoCreate(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeTodoRequest(w, r)
+	if !ok {
+		return
+	}
+	scope, err := user.WorkspaceScope(req.WorkspaceID, models.RoleEditor)
+	if err != nil {
+		writeModelError(w, err, "Workspace not found")
+		return
+	}
+
+	todo, err := scope.CreateTodo(req.todo())
+	if err != nil {
+		writeModelError(w, err, "Project not found")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/todos/%d", todo.ID))
+	writeJSON(w, http.StatusCreated, prepareTodo(r, todo))
+}
+
+func apiTodoGet(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	todo, err := user.GetTodo(id)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, prepareTodo(r, todo))
+}
+
+// apiTodoReplace overwrites every field; omitted fields fall back to their
+// defaults just like on create.
+func apiTodoReplace(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+	req, ok := decodeTodoRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if req.Priority == "" {
+		req.Priority = models.PriorityMedium
+	}
+	t := req.todo()
+	t.ID = id
+	saveAndWriteTodo(w, r, user, t)
+}
+
+// apiTodoPatch only changes the fields present in the body. Besides plain
+// JSON it accepts RFC 7396 JSON Merge Patch, where null resets a field.
+func apiTodoPatch(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+	patch, ok := decodeTodoPatch(w, r)
+	if !ok {
+		return
+	}
+
+	todo, err := user.PatchTodo(id, patch)
+	if err == nil {
+		todo, err = cascadeCompletion(r, user, todo)
+	}
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, prepareTodo(r, todo))
+}
+
+// apiTodoDelete moves a todo to the trash; see route_trash.go for getting it
+// back.
+func apiTodoDelete(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := user.TrashTodo(id, cascadeRequested(r)); err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiTodoSkip moves a recurring todo on to its next occurrence without
+// completing it.
+func apiTodoSkip(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	todo, err := user.SkipOccurrence(id)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, prepareTodo(r, todo))
+}
+
+// apiTodoEndSeries stops a todo's series from recurring; the todo itself
+// stays as it is.
+func apiTodoEndSeries(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	todo, err := user.EndSeries(id)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, prepareTodo(r, todo))
+}
+
+// apiTodoSubtaskOrder rearranges a todo's subtasks; the body lists every
+// subtask id in the new order: {"ids": [7, 5, 6]}.
+func apiTodoSubtaskOrder(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+	var req struct {
+		IDs []int `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("JSON decode error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	todo, err := user.ReorderSubtasks(id, req.IDs)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, prepareTodo(r, todo))
+}
+
+// apiTodoMove places a top-level todo in manual order, right after afterId
+// or at the top of its column when afterId is null. Giving status or
+// projectId moves it into another column as well:
+//
+//	{"afterId": 12}  {"afterId": null, "status": "in_progress", "projectId": 3}
+func apiTodoMove(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+	var req struct {
+		AfterID   *int           `json:"afterId"`
+		Status    *models.Status `json:"status"`
+		ProjectID *int           `json:"projectId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("JSON decode error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	move := models.TodoMove{Status: req.Status, ProjectID: req.ProjectID}
+	if req.AfterID != nil {
+		move.AfterID = *req.AfterID
+	}
+	todo, err := user.MoveTodo(id, move)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, prepareTodo(r, todo))
+}
+
+func saveAndWriteTodo(w http.ResponseWriter, r *http.Request, user models.User, t models.Todo) {
+	todo, err := user.ReplaceTodo(t)
+	if err == nil {
+		todo, err = cascadeCompletion(r, user, todo)
+	}
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, prepareTodo(r, todo))
+}
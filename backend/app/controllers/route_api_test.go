@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTodoRoutesDenyOtherUsers(t *testing.T) {
+	router := newRouter()
+	owner := newTestClient(t, router)
+	other := newTestClient(t, router)
+	todo := owner.createTodo("private")
+	api := fmt.Sprintf("/api/v1/todos/%d", todo.ID)
+
+	tests := []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, api, ""},
+		{http.MethodPut, api, `{"content": "taken over"}`},
+		{http.MethodPatch, api, `{"content": "taken over"}`},
+		{http.MethodDelete, api, ""},
+		{http.MethodPost, fmt.Sprintf("/todos/update/%d", todo.ID), `{"content": "taken over"}`},
+		{http.MethodPost, fmt.Sprintf("/todos/delete/%d", todo.ID), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := other.do(tt.method, tt.path, tt.body)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d; body %s", w.Code, http.StatusNotFound, w.Body)
+			}
+		})
+	}
+
+	w := owner.do(http.MethodGet, api, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("owner GET status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), `"Content":"private"`) {
+		t.Errorf("todo changed by another user: %s", w.Body)
+	}
+}
+
+func TestTodoListNamesMissingFilter(t *testing.T) {
+	c := newTestClient(t, newRouter())
+
+	tests := []struct {
+		query, message string
+	}{
+		{"project_id=999999", "Project not found"},
+		{"tags=999999", "Tag not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := c.do(http.MethodGet, "/api/v1/todos?"+tt.query, "")
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusNotFound)
+			}
+			if !strings.Contains(w.Body.String(), `"message":"`+tt.message+`"`) {
+				t.Errorf("body = %s, want message %q", w.Body, tt.message)
+			}
+		})
+	}
+}
+
+func TestMergePatchNulls(t *testing.T) {
+	c := newTestClient(t, newRouter())
+	todo := c.createTodo("keep me")
+	api := fmt.Sprintf("/api/v1/todos/%d", todo.ID)
+
+	tests := []struct {
+		body, want string
+		code       int
+	}{
+		{`{"content": null}`, `"fields":{"content":"must not be null"}`, http.StatusUnprocessableEntity},
+		{`{"description": null, "priority": null}`, `"Content":"keep me"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, api, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", mergePatchContentType)
+			r.AddCookie(c.cookie)
+			w := httptest.NewRecorder()
+			c.router.ServeHTTP(w, r)
+			if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("status = %d, body %s; want %d with %s", w.Code, w.Body, tt.code, tt.want)
+			}
+		})
+	}
+}
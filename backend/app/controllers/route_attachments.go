@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"todo_app/app/models"
+)
+
+// apiAttachmentList lists a todo's attachments together with how much of
+// the storage quota the user has used.
+func apiAttachmentList(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	attachments, err := user.GetAttachments(id)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	used, err := user.AttachmentUsage()
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	if attachments == nil {
+		attachments = []models.Attachment{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":      "success",
+		"attachments": attachments,
+		"used_bytes":  used,
+		"quota_bytes": models.AttachmentQuota(),
+	})
+}
+
+// apiAttachmentCreate uploads the "file" part of a multipart/form-data
+// body. The part is streamed to storage, never held in memory as a whole.
+func apiAttachmentCreate(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Expected a multipart/form-data body")
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			writeErrorFields(w, http.StatusUnprocessableEntity, "Validation failed",
+				map[string]string{"file": "is required"})
+			return
+		}
+		if err != nil {
+			log.Printf("Multipart error on %s %s: %v", r.Method, r.URL.Path, err)
+			writeError(w, http.StatusBadRequest, "Invalid multipart body")
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		attachment, err := user.CreateAttachment(id, part.FileName(), part)
+		part.Close()
+		if err != nil {
+			writeModelError(w, err, "Todo not found")
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/attachments/%d", attachment.ID))
+		writeJSON(w, http.StatusCreated, attachment)
+		return
+	}
+}
+
+// apiAttachmentDownload sends an attachment's content. It is always
+// offered as a download so that uploaded HTML or SVG never renders in the
+// app's origin.
+func apiAttachmentDownload(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := attachmentScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	attachment, content, err := user.OpenAttachment(id)
+	if err != nil {
+		writeModelError(w, err, "Attachment not found")
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+attachment.SHA256+`"`)
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		// Handles Range and If-None-Match requests.
+		http.ServeContent(w, r, "", attachment.CreatedAt, seeker)
+		return
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(attachment.Size))
+	io.Copy(w, content)
+}
+
+func apiAttachmentDelete(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := attachmentScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := user.DeleteAttachment(id); err != nil {
+		writeModelError(w, err, "Attachment not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -14,18 +15,23 @@ func signup(w http.ResponseWriter, r *http.Request) {
 		decoder := json.NewDecoder(r.Body)
 		if err := decoder.Decode(&req); err != nil {
 			log.Println("JSON decode error:", err)
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "Invalid JSON")
 			return
 		}
 		user := &models.User{
 			Name:     req.Name,
 			Email:    req.Email,
 			Password: req.Password,
+			TimeZone: req.TimeZone,
 		}
 
 		if err := user.CreateUser(); err != nil {
+			if verr, ok := err.(*models.ValidationError); ok {
+				writeErrorFields(w, http.StatusUnprocessableEntity, "Validation failed", verr.Fields)
+				return
+			}
 			log.Printf("CreateUser error: %v", err)
-			http.Error(w, "User creation failed", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "User creation failed")
 			return
 		}
 
@@ -43,31 +49,34 @@ func authenticate(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&req); err != nil {
 		log.Println("JSON decode error:", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
 		return
 	}
 	user, err := models.GetUserByEmail(req.Email)
 	if err != nil {
 		log.Printf("GetUserByEmail error: %v", err)
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
-	if user.Password == models.Encrypt(req.Password) {
-		session, err := user.CreateSession()
+	ok, needsRehash, err := models.VerifyPassword(req.Password, user.Password)
+	if err != nil {
+		log.Printf("VerifyPassword error for email %s: %v", req.Email, err)
+	}
+	if ok {
+		if needsRehash {
+			if err := user.UpdatePassword(req.Password); err != nil {
+				log.Printf("Password rehash failed for user %s: %v", user.Email, err)
+			} else {
+				log.Printf("Password rehashed for user %s", user.Email)
+			}
+		}
+		session, err := user.CreateSession(r.UserAgent(), clientIP(r))
 		if err != nil {
 			log.Printf("CreateSession error: %v", err)
-			http.Error(w, "Session creation failed", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "Session creation failed")
 			return
 		}
-		cookie := http.Cookie{
-			Name:     "_cookie",
-			Value:    session.UUID,
-			HttpOnly: true,
-			Path:     "/",
-			SameSite: http.SameSiteLaxMode,
-			Secure:   false, // 開発環境
-		}
-		http.SetCookie(w, &cookie)
+		setSessionCookie(w, session)
 
 		log.Printf("Session created successfully: %s for user %s", session.UUID, user.Email)
 
@@ -83,7 +92,7 @@ func authenticate(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(response)
 	} else {
 		log.Printf("Password mismatch for email: %s", req.Email)
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, "Invalid credentials")
 	}
 }
 
@@ -100,12 +109,7 @@ func logout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Cookieを削除
-	http.SetCookie(w, &http.Cookie{
-		Name:   "_cookie",
-		Value:  "",
-		MaxAge: -1,
-		Path:   "/",
-	})
+	clearSessionCookie(w)
 
 	// JSON レスポンス
 	w.Header().Set("Content-Type", "application/json")
@@ -115,3 +119,113 @@ func logout(w http.ResponseWriter, r *http.Request) {
 	}
 	json.NewEncoder(w).Encode(response)
 }
+
+// sessionList returns the current user's active sessions. Session UUIDs are
+// the cookie secret, so they are never included.
+func sessionList(w http.ResponseWriter, r *http.Request) {
+	sess, err := session(w, r)
+	if err != nil {
+		log.Printf("Session error in sessionList: %v", err)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, err := sess.GetUserBySession()
+	if err != nil {
+		log.Printf("GetUserBySession error in sessionList: %v", err)
+		writeError(w, http.StatusInternalServerError, "User not found")
+		return
+	}
+
+	sessions, err := user.GetSessions()
+	if err != nil {
+		log.Printf("GetSessions error: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get sessions")
+		return
+	}
+
+	sessionsResponse := []map[string]interface{}{}
+	for _, s := range sessions {
+		sessionsResponse = append(sessionsResponse, map[string]interface{}{
+			"id":           s.ID,
+			"user_agent":   s.UserAgent,
+			"ip":           s.IP,
+			"created_at":   s.CreatedAt,
+			"last_seen_at": s.LastSeenAt,
+			"expires_at":   s.ExpiresAt(),
+			"current":      s.UUID == sess.UUID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"status":   "success",
+		"sessions": sessionsResponse,
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func sessionRevoke(w http.ResponseWriter, r *http.Request, id int) {
+	sess, err := session(w, r)
+	if err != nil {
+		log.Printf("Session error in sessionRevoke: %v", err)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, err := sess.GetUserBySession()
+	if err != nil {
+		log.Printf("GetUserBySession error in sessionRevoke: %v", err)
+		writeError(w, http.StatusInternalServerError, "User not found")
+		return
+	}
+
+	if err := user.DeleteSession(id); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Session not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+	if id == sess.ID {
+		clearSessionCookie(w)
+	}
+	log.Printf("Session %d revoked by user %s", id, user.Email)
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]string{
+		"status":  "success",
+		"message": "Session revoked",
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// sessionRevokeOthers logs the user out everywhere except the current device.
+func sessionRevokeOthers(w http.ResponseWriter, r *http.Request) {
+	sess, err := session(w, r)
+	if err != nil {
+		log.Printf("Session error in sessionRevokeOthers: %v", err)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, err := sess.GetUserBySession()
+	if err != nil {
+		log.Printf("GetUserBySession error in sessionRevokeOthers: %v", err)
+		writeError(w, http.StatusInternalServerError, "User not found")
+		return
+	}
+
+	n, err := user.DeleteOtherSessions(sess.UUID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+	log.Printf("%d other sessions revoked by user %s", n, user.Email)
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": "Other sessions revoked",
+		"revoked": n,
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"todo_app/app/models"
+)
+
+type commentRequest struct {
+	Body string `json:"body"`
+}
+
+func decodeCommentRequest(w http.ResponseWriter, r *http.Request) (req commentRequest, ok bool) {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("JSON decode error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return req, false
+	}
+	return req, true
+}
+
+// prepareComment renders the Markdown body when the client asked for it
+// with ?render=html.
+func prepareComment(r *http.Request, c models.Comment) models.Comment {
+	if renderRequested(r) {
+		c.RenderBody()
+	}
+	return c
+}
+
+// apiCommentList is the discussion of a todo, oldest first:
+//
+//	limit=50  after=<next_after>  render=html
+func apiCommentList(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+	v := r.URL.Query()
+	verr := &models.ValidationError{}
+	limit, after := models.DefaultCommentLimit, 0
+	if s := v.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil {
+			verr.Add("limit", "must be a positive integer")
+		}
+	}
+	if s := v.Get("after"); s != "" {
+		var err error
+		if after, err = strconv.Atoi(s); err != nil || after < 1 {
+			verr.Add("after", "must be a comment id")
+		}
+	}
+	if err := verr.Err(); err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+
+	comments, err := user.GetComments(id, after, limit)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	if comments == nil {
+		comments = []models.Comment{}
+	}
+	for i := range comments {
+		comments[i] = prepareComment(r, comments[i])
+	}
+	nextAfter := 0
+	if len(comments) == limit {
+		nextAfter = comments[len(comments)-1].ID
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "success",
+		"comments":   comments,
+		"next_after": nextAfter,
+	})
+}
+
+func apiCommentCreate(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+	req, ok := decodeCommentRequest(w, r)
+	if !ok {
+		return
+	}
+
+	comment, err := user.CreateComment(id, req.Body)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/todos/%d/comments", id))
+	writeJSON(w, http.StatusCreated, prepareComment(r, comment))
+}
+
+// apiCommentPatch edits one of the user's own comments.
+func apiCommentPatch(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := commentScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+	req, ok := decodeCommentRequest(w, r)
+	if !ok {
+		return
+	}
+
+	comment, err := user.UpdateComment(id, req.Body)
+	if err != nil {
+		writeModelError(w, err, "Comment not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, prepareComment(r, comment))
+}
+
+func apiCommentDelete(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := commentScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := user.DeleteComment(id); err != nil {
+		writeModelError(w, err, "Comment not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"todo_app/app/models"
+)
+
+// apiTodoHistory lists what happened to a todo, newest first.
+func apiTodoHistory(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	entries, err := user.GetTodoHistory(id)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	if entries == nil {
+		entries = []models.HistoryEntry{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"history": entries,
+	})
+}
+
+// apiTodoRevert puts a todo back to how it was right after the given
+// history entry.
+func apiTodoRevert(w http.ResponseWriter, r *http.Request, id, versionID int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	todo, err := user.RevertTodo(id, versionID)
+	if err != nil {
+		writeModelError(w, err, "Version not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, prepareTodo(r, todo))
+}
+
+// apiTodoStatusChanges lists the columns a todo went through, oldest
+// first.
+func apiTodoStatusChanges(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	changes, err := user.GetStatusChanges(id)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	if changes == nil {
+		changes = []models.StatusChange{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":         "success",
+		"status_changes": changes,
+	})
+}
+
+// apiActivityList is the history of all the user's todos, newest first:
+//
+//	limit=50  before=<next_before>
+func apiActivityList(w http.ResponseWriter, r *http.Request) {
+	user, ok := workspaceScope(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+	v := r.URL.Query()
+	verr := &models.ValidationError{}
+	limit, before := models.DefaultHistoryLimit, 0
+	if s := v.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil {
+			verr.Add("limit", "must be a positive integer")
+		}
+	}
+	if s := v.Get("before"); s != "" {
+		var err error
+		if before, err = strconv.Atoi(s); err != nil || before < 1 {
+			verr.Add("before", "must be a history entry id")
+		}
+	}
+	if err := verr.Err(); err != nil {
+		writeModelError(w, err, "Not found")
+		return
+	}
+
+	entries, err := user.GetActivity(before, limit)
+	if err != nil {
+		writeModelError(w, err, "Not found")
+		return
+	}
+	if entries == nil {
+		entries = []models.HistoryEntry{}
+	}
+	nextBefore := 0
+	if len(entries) == limit {
+		nextBefore = entries[len(entries)-1].ID
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":      "success",
+		"activity":    entries,
+		"next_before": nextBefore,
+	})
+}
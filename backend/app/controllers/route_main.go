@@ -1,11 +1,11 @@
 package controllers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"io"
 	"log"
 	"net/http"
-	"time"
 
 	"todo_app/app/models"
 )
@@ -14,41 +14,35 @@ func index(w http.ResponseWriter, r *http.Request) {
 	sess, err := session(w, r)
 	if err != nil {
 		log.Printf("Session error: %v", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 	user, err := sess.GetUserBySession()
 	if err != nil {
 		log.Printf("GetUserBySession error: %v", err)
-		http.Error(w, "User not found", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "User not found")
 		return
 	}
 
-	todos, err := user.GetTodosByUser()
+	q, err := parseTodoQuery(r)
 	if err != nil {
-		log.Printf("GetTodosByUser error: %v", err)
-		http.Error(w, "Failed to get todos", http.StatusInternalServerError)
+		writeModelError(w, err, "Not found")
 		return
 	}
-
-	// Transform todos to include all fields
-	var todosResponse []map[string]interface{}
-	for _, todo := range todos {
-		todosResponse = append(todosResponse, map[string]interface{}{
-			"ID":        todo.ID,
-			"Content":   todo.Content,
-			"UserID":    todo.UserID,
-			"Priority":  todo.Priority,
-			"Status":    todo.Status,
-			"DueDate":   todo.DueDate,
-			"CreatedAt": todo.CreatedAt,
-		})
+	if !checkTodoQueryRefs(w, user, q) {
+		return
+	}
+	todos, nextCursor, err := user.ListTodos(q)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]interface{}{
-		"status": "success",
-		"todos":  todosResponse,
+		"status":      "success",
+		"todos":       prepareTodos(r, todos),
+		"next_cursor": nextCursor,
 		"user": map[string]interface{}{
 			"id":    user.ID,
 			"name":  user.Name,
@@ -62,45 +56,60 @@ func todoSave(w http.ResponseWriter, r *http.Request) {
 	sess, err := session(w, r)
 	if err != nil {
 		log.Printf("Session error in todoSave: %v", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
 	user, err := sess.GetUserBySession()
 	if err != nil {
 		log.Printf("GetUserBySession error in todoSave: %v", err)
-		http.Error(w, "User not found", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "User not found")
 		return
 	}
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Printf("Body read error: %v", err)
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Failed to read request body")
 		return
 	}
 
 	var req struct {
-		Content  string `json:"content"`
-		Priority string `json:"priority"`
-		DueDate  string `json:"dueDate"`
+		Content     string          `json:"content"`
+		Description string          `json:"description"`
+		Priority    models.Priority `json:"priority"`
+		DueDate     string          `json:"dueDate"`
+		DueTime     string          `json:"dueTime"`
+		ProjectID   *int            `json:"projectId"`
+		ParentID    *int            `json:"parentId"`
+		Recurrence  string          `json:"recurrence"`
 	}
 	if err := json.Unmarshal(body, &req); err != nil {
 		log.Printf("JSON unmarshal error: %v", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
 		return
 	}
 
-	if err := user.CreateTodo(req.Content, req.Priority, req.DueDate); err != nil {
-		log.Printf("CreateTodo error: %v", err)
-		http.Error(w, "Failed to create todo", http.StatusInternalServerError)
+	todo, err := user.CreateTodo(models.Todo{
+		Content:     req.Content,
+		Description: req.Description,
+		Priority:    req.Priority,
+		DueDate:     req.DueDate,
+		DueTime:     req.DueTime,
+		ProjectID:   req.ProjectID,
+		ParentID:    req.ParentID,
+		Recurrence:  req.Recurrence,
+	})
+	if err != nil {
+		writeModelError(w, err, "Project not found")
 		return
 	}
 
 	// JSON レスポンス
 	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{
+	response := map[string]interface{}{
 		"status":  "success",
 		"message": "Todo created successfully",
+		"todo":    prepareTodo(r, todo),
 	}
 	json.NewEncoder(w).Encode(response)
 }
@@ -109,66 +118,43 @@ func todoUpdate(w http.ResponseWriter, r *http.Request, id int) {
 	sess, err := session(w, r)
 	if err != nil {
 		log.Printf("Session error in todoUpdate: %v", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
 	user, err := sess.GetUserBySession()
 	if err != nil {
 		log.Printf("GetUserBySession error in todoUpdate: %v", err)
-		http.Error(w, "User not found", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "User not found")
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Body read error in todoUpdate: %v", err)
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+	if user, err = user.TodoScope(id, models.RoleEditor); err != nil {
+		writeModelError(w, err, "Todo not found")
 		return
 	}
 
-	var req struct {
-		Content  string `json:"content"`
-		Priority string `json:"priority"`
-		Status   string `json:"status"`
-		DueDate  string `json:"dueDate"`
-	}
-	if err := json.Unmarshal(body, &req); err != nil {
-		log.Printf("JSON unmarshal error in todoUpdate: %v", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	// Only the fields sent by the client change, so toggling the status
+	// does not reset priority or due date.
+	patch, ok := decodeTodoPatch(w, r)
+	if !ok {
 		return
 	}
-
-	// デフォルト値の設定
-	if req.Priority == "" {
-		req.Priority = "medium"
-	}
-	if req.Status == "" {
-		req.Status = "todo"
-	}
-	if req.DueDate == "" {
-		req.DueDate = time.Now().Format("2006-01-02")
-	}
-
-	t := models.Todo{
-		ID:       id,
-		Content:  req.Content,
-		UserID:   user.ID,
-		Priority: req.Priority,
-		Status:   req.Status,
-		DueDate:  req.DueDate,
+	todo, err := user.PatchTodo(id, patch)
+	if err == nil {
+		todo, err = cascadeCompletion(r, user, todo)
 	}
-	if err := t.UpdateTodo(); err != nil {
-		log.Printf("UpdateTodo error: %v", err)
-		http.Error(w, "Failed to update todo", http.StatusInternalServerError)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
 		return
 	}
 
 	// JSON レスポンス
 	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{
+	response := map[string]interface{}{
 		"status":  "success",
 		"message": "Todo updated successfully",
+		"todo":    prepareTodo(r, todo),
 	}
 	json.NewEncoder(w).Encode(response)
 }
@@ -177,27 +163,33 @@ func todoDelete(w http.ResponseWriter, r *http.Request, id int) {
 	sess, err := session(w, r)
 	if err != nil {
 		log.Printf("Session error in todoDelete: %v", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	_, err = sess.GetUserBySession()
+	user, err := sess.GetUserBySession()
 	if err != nil {
 		log.Printf("GetUserBySession error in todoDelete: %v", err)
-		http.Error(w, "User not found", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "User not found")
 		return
 	}
 
-	t, err := models.GetTodo(id)
+	if user, err = user.TodoScope(id, models.RoleEditor); err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	t, err := user.GetTodo(id)
 	if err != nil {
-		log.Printf("GetTodo error in todoDelete: %v", err)
-		http.Error(w, "Todo not found", http.StatusNotFound)
+		if err != sql.ErrNoRows {
+			log.Printf("GetTodo error in todoDelete: %v", err)
+		}
+		writeError(w, http.StatusNotFound, "Todo not found")
 		return
 	}
 
-	if err := t.DeleteTodo(); err != nil {
+	if err := t.DeleteTodo(cascadeRequested(r)); err != nil {
 		log.Printf("DeleteTodo error: %v", err)
-		http.Error(w, "Failed to delete todo", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Failed to delete todo")
 		return
 	}
 
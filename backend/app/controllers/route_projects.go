@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"todo_app/app/models"
+)
+
+type projectRequest struct {
+	WorkspaceID int     `json:"workspaceId"` // only read on create, 0 for the personal space
+	Name        *string `json:"name"`
+	Color       *string `json:"color"`
+}
+
+func decodeProjectRequest(w http.ResponseWriter, r *http.Request) (req projectRequest, ok bool) {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("JSON decode error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return req, false
+	}
+	return req, true
+}
+
+func apiProjectList(w http.ResponseWriter, r *http.Request) {
+	user, ok := workspaceScope(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	projects, err := user.GetProjects()
+	if err != nil {
+		writeModelError(w, err, "Project not found")
+		return
+	}
+	if projects == nil {
+		projects = []models.Project{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":   "success",
+		"projects": projects,
+	})
+}
+
+func apiProjectCreate(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeProjectRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var name, color string
+	if req.Name != nil {
+		name = *req.Name
+	}
+	if req.Color != nil {
+		color = *req.Color
+	}
+	scope, err := user.WorkspaceScope(req.WorkspaceID, models.RoleEditor)
+	if err != nil {
+		writeModelError(w, err, "Workspace not found")
+		return
+	}
+	project, err := scope.CreateProject(name, color)
+	if err != nil {
+		writeModelError(w, err, "Project not found")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/projects/%d", project.ID))
+	writeJSON(w, http.StatusCreated, project)
+}
+
+func apiProjectGet(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := projectScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	project, err := user.GetProject(id)
+	if err != nil {
+		writeModelError(w, err, "Project not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, project)
+}
+
+func apiProjectPatch(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := projectScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+	req, ok := decodeProjectRequest(w, r)
+	if !ok {
+		return
+	}
+
+	project, err := user.GetProject(id)
+	if err != nil {
+		writeModelError(w, err, "Project not found")
+		return
+	}
+	if req.Name != nil {
+		project.Name = *req.Name
+	}
+	if req.Color != nil {
+		project.Color = *req.Color
+	}
+	project, err = project.UpdateProject()
+	if err != nil {
+		writeModelError(w, err, "Project not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, project)
+}
+
+// apiProjectDelete removes the project but keeps its todos, which move back
+// to "no project".
+func apiProjectDelete(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := projectScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	project := models.Project{ID: id, UserID: user.ID}
+	if err := project.DeleteProject(); err != nil {
+		writeModelError(w, err, "Project not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiProjectTodos lists the todos in one project and accepts the same query
+// parameters as /api/v1/todos.
+func apiProjectTodos(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := projectScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+	if _, err := user.GetProject(id); err != nil {
+		writeModelError(w, err, "Project not found")
+		return
+	}
+	q, err := parseTodoQuery(r)
+	if err != nil {
+		writeModelError(w, err, "Not found")
+		return
+	}
+	q.ProjectID = &id
+	if !checkTodoQueryRefs(w, user, q) {
+		return
+	}
+
+	todos, nextCursor, err := user.ListTodos(q)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":      "success",
+		"todos":       prepareTodos(r, todos),
+		"next_cursor": nextCursor,
+	})
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"todo_app/app/models"
+)
+
+// reminderRequest sets either an absolute time or an offset before the
+// todo's due date: {"remindAt": "2024-05-01T09:00:00+02:00"} or
+// {"offsetMinutes": 1440, "channel": "email"}.
+type reminderRequest struct {
+	RemindAt      *time.Time `json:"remindAt"`
+	OffsetMinutes *int       `json:"offsetMinutes"`
+	Channel       string     `json:"channel"`
+}
+
+func apiReminderList(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	reminders, err := user.GetReminders(id)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	if reminders == nil {
+		reminders = []models.Reminder{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    "success",
+		"reminders": reminders,
+	})
+}
+
+func apiReminderCreate(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+	var req reminderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("JSON decode error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	reminder, err := user.CreateReminder(id, req.RemindAt, req.OffsetMinutes, req.Channel)
+	if err != nil {
+		writeModelError(w, err, "Todo not found")
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/todos/%d/reminders", id))
+	writeJSON(w, http.StatusCreated, reminder)
+}
+
+func apiReminderDelete(w http.ResponseWriter, r *http.Request, id int) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := user.DeleteReminder(id); err != nil {
+		writeModelError(w, err, "Reminder not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiNotificationList returns the in-app inbox, newest first; ?unread=true
+// leaves out notifications that were already read.
+func apiNotificationList(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	var unreadOnly bool
+	if s := r.URL.Query().Get("unread"); s != "" {
+		var err error
+		if unreadOnly, err = strconv.ParseBool(s); err != nil {
+			writeErrorFields(w, http.StatusUnprocessableEntity, "Validation failed",
+				map[string]string{"unread": "must be true or false"})
+			return
+		}
+	}
+
+	notifications, err := user.GetNotifications(unreadOnly)
+	if err != nil {
+		writeModelError(w, err, "Notification not found")
+		return
+	}
+	if notifications == nil {
+		notifications = []models.Notification{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":        "success",
+		"notifications": notifications,
+	})
+}
+
+func apiNotificationRead(w http.ResponseWriter, r *http.Request, id int) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	notification, err := user.MarkNotificationRead(id)
+	if err != nil {
+		writeModelError(w, err, "Notification not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, notification)
+}
+
+func apiNotificationReadAll(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	marked, err := user.MarkAllNotificationsRead()
+	if err != nil {
+		writeModelError(w, err, "Notification not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"marked": marked,
+	})
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"todo_app/app/models"
+)
+
+type tagRequest struct {
+	WorkspaceID int     `json:"workspaceId"` // only read on create, 0 for the personal space
+	Name        *string `json:"name"`
+	Color       *string `json:"color"`
+}
+
+func decodeTagRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		log.Printf("JSON decode error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return false
+	}
+	return true
+}
+
+func apiTagList(w http.ResponseWriter, r *http.Request) {
+	user, ok := workspaceScope(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	tags, err := user.GetTags()
+	if err != nil {
+		writeModelError(w, err, "Tag not found")
+		return
+	}
+	if tags == nil {
+		tags = []models.Tag{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"tags":   tags,
+	})
+}
+
+func apiTagCreate(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req tagRequest
+	if !decodeTagRequest(w, r, &req) {
+		return
+	}
+
+	var name, color string
+	if req.Name != nil {
+		name = *req.Name
+	}
+	if req.Color != nil {
+		color = *req.Color
+	}
+	scope, err := user.WorkspaceScope(req.WorkspaceID, models.RoleEditor)
+	if err != nil {
+		writeModelError(w, err, "Workspace not found")
+		return
+	}
+	tag, err := scope.CreateTag(name, color)
+	if err != nil {
+		writeModelError(w, err, "Tag not found")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/tags/%d", tag.ID))
+	writeJSON(w, http.StatusCreated, tag)
+}
+
+func apiTagGet(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := tagScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	tag, err := user.GetTag(id)
+	if err != nil {
+		writeModelError(w, err, "Tag not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, tag)
+}
+
+// apiTagPatch renames or recolours a tag.
+func apiTagPatch(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := tagScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+	var req tagRequest
+	if !decodeTagRequest(w, r, &req) {
+		return
+	}
+
+	tag, err := user.GetTag(id)
+	if err != nil {
+		writeModelError(w, err, "Tag not found")
+		return
+	}
+	if req.Name != nil {
+		tag.Name = *req.Name
+	}
+	if req.Color != nil {
+		tag.Color = *req.Color
+	}
+	tag, err = tag.UpdateTag()
+	if err != nil {
+		writeModelError(w, err, "Tag not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, tag)
+}
+
+func apiTagDelete(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := tagScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	tag := models.Tag{ID: id, UserID: user.ID}
+	if err := tag.DeleteTag(); err != nil {
+		writeModelError(w, err, "Tag not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiTagMerge folds the tag {id} into the tag given as "into" and returns
+// the surviving tag.
+func apiTagMerge(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := tagScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+	var req struct {
+		Into int `json:"into"`
+	}
+	if !decodeTagRequest(w, r, &req) {
+		return
+	}
+
+	tag, err := user.MergeTag(id, req.Into)
+	if err != nil {
+		writeModelError(w, err, "Tag not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, tag)
+}
+
+func apiTodoTag(w http.ResponseWriter, r *http.Request, id, tagID int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	todo, err := user.TagTodo(id, tagID)
+	if err != nil {
+		writeModelError(w, err, "Todo or tag not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, prepareTodo(r, todo))
+}
+
+func apiTodoUntag(w http.ResponseWriter, r *http.Request, id, tagID int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	todo, err := user.UntagTodo(id, tagID)
+	if err != nil {
+		writeModelError(w, err, "Todo or tag not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, prepareTodo(r, todo))
+}
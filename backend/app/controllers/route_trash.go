@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"net/http"
+
+	"todo_app/app/models"
+)
+
+// apiTrashList returns the trashed todos, most recently deleted first.
+func apiTrashList(w http.ResponseWriter, r *http.Request) {
+	user, ok := workspaceScope(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	todos, err := user.GetTrash()
+	if err != nil {
+		writeModelError(w, err, "Not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"todos":  prepareTodos(r, todos),
+	})
+}
+
+func apiTrashRestore(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	todo, err := user.RestoreTodo(id)
+	if err != nil {
+		writeModelError(w, err, "Todo not found in trash")
+		return
+	}
+	writeJSON(w, http.StatusOK, prepareTodo(r, todo))
+}
+
+// apiTrashPurge deletes a trashed todo for good.
+func apiTrashPurge(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := todoScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := user.PurgeTodo(id); err != nil {
+		writeModelError(w, err, "Todo not found in trash")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiTrashEmpty(w http.ResponseWriter, r *http.Request) {
+	user, ok := workspaceScope(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	n, err := user.EmptyTrash()
+	if err != nil {
+		writeModelError(w, err, "Not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"purged": n,
+	})
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"todo_app/app/models"
+)
+
+type meRequest struct {
+	TimeZone *string `json:"timeZone"`
+}
+
+// meResponse leaves out the password hash and the user's todos.
+func meResponse(user models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"status": "success",
+		"user": map[string]interface{}{
+			"id":         user.ID,
+			"name":       user.Name,
+			"email":      user.Email,
+			"time_zone":  user.TimeZone,
+			"created_at": user.CreatedAt,
+		},
+	}
+}
+
+func apiMeGet(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, meResponse(user))
+}
+
+// apiMePatch updates the current user's settings. Changing the time zone
+// keeps the instant of timed todos and moves their due dates accordingly.
+func apiMePatch(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req meRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("JSON decode error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.TimeZone != nil {
+		if err := user.UpdateTimeZone(*req.TimeZone); err != nil {
+			writeModelError(w, err, "User not found")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, meResponse(user))
+}
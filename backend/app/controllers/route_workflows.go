@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"todo_app/app/models"
+)
+
+type workflowRequest struct {
+	Columns []struct {
+		Key      models.Status `json:"key"`
+		Name     string        `json:"name"`
+		Done     bool          `json:"done"`
+		WIPLimit int           `json:"wipLimit"`
+	} `json:"columns"`
+	Transitions []struct {
+		From models.Status `json:"from"`
+		To   models.Status `json:"to"`
+	} `json:"transitions"`
+}
+
+func (req workflowRequest) workflow() models.Workflow {
+	wf := models.Workflow{
+		Columns:     []models.WorkflowColumn{},
+		Transitions: []models.WorkflowTransition{},
+	}
+	for _, c := range req.Columns {
+		wf.Columns = append(wf.Columns, models.WorkflowColumn{Key: c.Key, Name: c.Name, Done: c.Done, WIPLimit: c.WIPLimit})
+	}
+	for _, t := range req.Transitions {
+		wf.Transitions = append(wf.Transitions, models.WorkflowTransition{From: t.From, To: t.To})
+	}
+	return wf
+}
+
+func apiWorkflowGet(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := projectScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	wf, err := user.GetWorkflow(id)
+	if err != nil {
+		writeModelError(w, err, "Project not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, wf)
+}
+
+// apiWorkflowPut replaces a project's workflow:
+//
+//	{"columns": [{"key": "review", "name": "Review", "done": false, "wipLimit": 3}, ...],
+//	 "transitions": [{"from": "todo", "to": "review"}, ...]}
+//
+// An empty transition list allows every move.
+func apiWorkflowPut(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := projectScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+	var req workflowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("JSON decode error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	wf, err := user.SetWorkflow(id, req.workflow())
+	if err != nil {
+		writeModelError(w, err, "Project not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, wf)
+}
+
+// apiWorkflowDelete puts a project back on the default workflow.
+func apiWorkflowDelete(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := projectScope(w, r, id, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	wf, err := user.ResetWorkflow(id)
+	if err != nil {
+		writeModelError(w, err, "Project not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, wf)
+}
+
+// apiProjectBoard lists a project's top-level todos grouped by workflow
+// column, each column in manual order.
+func apiProjectBoard(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := projectScope(w, r, id, models.RoleViewer)
+	if !ok {
+		return
+	}
+	writeBoard(w, r, user, id)
+}
+
+// apiBoard is the board of todos without a project, in the personal space
+// or the workspace given by ?workspace_id.
+func apiBoard(w http.ResponseWriter, r *http.Request) {
+	user, ok := workspaceScope(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+	writeBoard(w, r, user, 0)
+}
+
+func writeBoard(w http.ResponseWriter, r *http.Request, user models.User, projectID int) {
+	wf, board, err := user.GetBoard(projectID)
+	if err != nil {
+		writeModelError(w, err, "Project not found")
+		return
+	}
+	for i := range board {
+		board[i].Todos = prepareTodos(r, board[i].Todos)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":      "success",
+		"project_id":  wf.ProjectID,
+		"transitions": wf.Transitions,
+		"columns":     board,
+	})
+}
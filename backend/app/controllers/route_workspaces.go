@@ -0,0 +1,349 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"todo_app/app/models"
+)
+
+// resolveScope is currentUser followed by resolve, which picks the user to
+// act as for the request. Unknown resources and workspaces the user is not
+// a member of are answered with a 404 saying notFound, too low a role with
+// a 403.
+func resolveScope(w http.ResponseWriter, r *http.Request, notFound string, resolve func(*models.User) (models.User, error)) (scope models.User, ok bool) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return scope, false
+	}
+	scope, err := resolve(&user)
+	if err != nil {
+		writeModelError(w, err, notFound)
+		return scope, false
+	}
+	return scope, true
+}
+
+// todoScope resolves the user to act as on todo id, which needs at least
+// role need when the todo is in a workspace.
+func todoScope(w http.ResponseWriter, r *http.Request, id int, need models.Role) (models.User, bool) {
+	return resolveScope(w, r, "Todo not found", func(u *models.User) (models.User, error) {
+		return u.TodoScope(id, need)
+	})
+}
+
+func projectScope(w http.ResponseWriter, r *http.Request, id int, need models.Role) (models.User, bool) {
+	return resolveScope(w, r, "Project not found", func(u *models.User) (models.User, error) {
+		return u.ProjectScope(id, need)
+	})
+}
+
+func tagScope(w http.ResponseWriter, r *http.Request, id int, need models.Role) (models.User, bool) {
+	return resolveScope(w, r, "Tag not found", func(u *models.User) (models.User, error) {
+		return u.TagScope(id, need)
+	})
+}
+
+func commentScope(w http.ResponseWriter, r *http.Request, id int, need models.Role) (models.User, bool) {
+	return resolveScope(w, r, "Comment not found", func(u *models.User) (models.User, error) {
+		return u.CommentScope(id, need)
+	})
+}
+
+func attachmentScope(w http.ResponseWriter, r *http.Request, id int, need models.Role) (models.User, bool) {
+	return resolveScope(w, r, "Attachment not found", func(u *models.User) (models.User, error) {
+		return u.AttachmentScope(id, need)
+	})
+}
+
+// workspaceScope resolves the user to act as on the workspace named by the
+// workspace_id query parameter, or on the personal space without one. It
+// is used by the listings, which otherwise cannot tell which space to read.
+func workspaceScope(w http.ResponseWriter, r *http.Request, need models.Role) (models.User, bool) {
+	id := 0
+	if s := r.URL.Query().Get("workspace_id"); s != "" {
+		var err error
+		if id, err = strconv.Atoi(s); err != nil || id < 1 {
+			writeErrorFields(w, http.StatusUnprocessableEntity, "Validation failed",
+				map[string]string{"workspace_id": "must be a workspace id"})
+			return models.User{}, false
+		}
+	}
+	return resolveScope(w, r, "Workspace not found", func(u *models.User) (models.User, error) {
+		return u.WorkspaceScope(id, need)
+	})
+}
+
+// withWorkspaceMember is withID for routes that also carry a numeric
+// {userId}.
+func withWorkspaceMember(fn func(http.ResponseWriter, *http.Request, int, int)) http.HandlerFunc {
+	return withID(func(w http.ResponseWriter, r *http.Request, id int) {
+		userID, err := strconv.Atoi(r.PathValue("userId"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		fn(w, r, id, userID)
+	})
+}
+
+func decodeWorkspaceRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		log.Printf("JSON decode error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return false
+	}
+	return true
+}
+
+func apiWorkspaceList(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	workspaces, err := user.GetWorkspaces()
+	if err != nil {
+		writeModelError(w, err, "Workspace not found")
+		return
+	}
+	if workspaces == nil {
+		workspaces = []models.Workspace{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "success",
+		"workspaces": workspaces,
+	})
+}
+
+// apiWorkspaceCreate creates a workspace owned by the user: {"name": "Team"}.
+func apiWorkspaceCreate(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decodeWorkspaceRequest(w, r, &req) {
+		return
+	}
+
+	workspace, err := user.CreateWorkspace(req.Name)
+	if err != nil {
+		writeModelError(w, err, "Workspace not found")
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/workspaces/%d", workspace.ID))
+	writeJSON(w, http.StatusCreated, workspace)
+}
+
+func apiWorkspaceGet(w http.ResponseWriter, r *http.Request, id int) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	workspace, err := user.GetWorkspace(id)
+	if err != nil {
+		writeModelError(w, err, "Workspace not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, workspace)
+}
+
+func apiWorkspacePatch(w http.ResponseWriter, r *http.Request, id int) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decodeWorkspaceRequest(w, r, &req) {
+		return
+	}
+
+	workspace, err := user.RenameWorkspace(id, req.Name)
+	if err != nil {
+		writeModelError(w, err, "Workspace not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, workspace)
+}
+
+// apiWorkspaceDelete removes a workspace; its todos, projects and tags move
+// to the owner's personal space.
+func apiWorkspaceDelete(w http.ResponseWriter, r *http.Request, id int) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := user.DeleteWorkspace(id); err != nil {
+		writeModelError(w, err, "Workspace not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiMemberList(w http.ResponseWriter, r *http.Request, id int) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := user.GetMembers(id)
+	if err != nil {
+		writeModelError(w, err, "Workspace not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"members": members,
+	})
+}
+
+// apiMemberPatch changes a member's role: {"role": "viewer"}.
+func apiMemberPatch(w http.ResponseWriter, r *http.Request, id, userID int) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Role models.Role `json:"role"`
+	}
+	if !decodeWorkspaceRequest(w, r, &req) {
+		return
+	}
+
+	member, err := user.SetMemberRole(id, userID, req.Role)
+	if err != nil {
+		writeModelError(w, err, "Member not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, member)
+}
+
+// apiMemberDelete removes a member, or lets members leave by giving their
+// own id.
+func apiMemberDelete(w http.ResponseWriter, r *http.Request, id, userID int) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := user.RemoveMember(id, userID); err != nil {
+		writeModelError(w, err, "Member not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiWorkspaceInvitationList lists the workspace's pending invitations.
+func apiWorkspaceInvitationList(w http.ResponseWriter, r *http.Request, id int) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	invitations, err := user.GetWorkspaceInvitations(id)
+	if err != nil {
+		writeModelError(w, err, "Workspace not found")
+		return
+	}
+	writeInvitations(w, invitations)
+}
+
+// apiInvitationCreate invites a user by email:
+//
+//	{"email": "sam@example.com", "role": "editor"}
+func apiInvitationCreate(w http.ResponseWriter, r *http.Request, id int) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Email string      `json:"email"`
+		Role  models.Role `json:"role"`
+	}
+	if !decodeWorkspaceRequest(w, r, &req) {
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.RoleEditor
+	}
+
+	invitation, err := user.InviteMember(id, req.Email, req.Role)
+	if err != nil {
+		writeModelError(w, err, "Workspace not found")
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/invitations/%d", invitation.ID))
+	writeJSON(w, http.StatusCreated, invitation)
+}
+
+// apiInvitationList lists the pending invitations sent to the user.
+func apiInvitationList(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	invitations, err := user.GetInvitations()
+	if err != nil {
+		writeModelError(w, err, "Invitation not found")
+		return
+	}
+	writeInvitations(w, invitations)
+}
+
+func writeInvitations(w http.ResponseWriter, invitations []models.Invitation) {
+	if invitations == nil {
+		invitations = []models.Invitation{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":      "success",
+		"invitations": invitations,
+	})
+}
+
+func apiInvitationAccept(w http.ResponseWriter, r *http.Request, id int) {
+	respondToInvitation(w, r, id, true)
+}
+
+func apiInvitationDecline(w http.ResponseWriter, r *http.Request, id int) {
+	respondToInvitation(w, r, id, false)
+}
+
+func respondToInvitation(w http.ResponseWriter, r *http.Request, id int, accept bool) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	invitation, err := user.RespondToInvitation(id, accept)
+	if err != nil {
+		writeModelError(w, err, "Invitation not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, invitation)
+}
+
+// apiInvitationRevoke withdraws a pending invitation; only the workspace
+// owner can.
+func apiInvitationRevoke(w http.ResponseWriter, r *http.Request, id int) {
+	user, _, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := user.RevokeInvitation(id); err != nil {
+		writeModelError(w, err, "Invitation not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
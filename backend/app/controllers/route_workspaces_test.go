@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"todo_app/app/models"
+)
+
+func TestWorkspaceTodoRoutesEnforceRoles(t *testing.T) {
+	router := newRouter()
+	owner, editor, viewer, outsider := newTestClient(t, router), newTestClient(t, router), newTestClient(t, router), newTestClient(t, router)
+	ws := owner.createWorkspace()
+	editor.joinWorkspace(owner, ws.ID, models.RoleEditor)
+	viewer.joinWorkspace(owner, ws.ID, models.RoleViewer)
+
+	scope, err := owner.user.WorkspaceScope(ws.ID, models.RoleOwner)
+	if err != nil {
+		t.Fatalf("WorkspaceScope: %v", err)
+	}
+	todo, err := scope.CreateTodo(models.Todo{Content: "shared"})
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	comment, err := scope.CreateComment(todo.ID, "note")
+	if err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+	tag, err := scope.CreateTag("team", "")
+	if err != nil {
+		t.Fatalf("CreateTag: %v", err)
+	}
+	api := fmt.Sprintf("/api/v1/todos/%d", todo.ID)
+	inWorkspace := fmt.Sprintf("?workspace_id=%d", ws.ID)
+
+	tests := []struct {
+		method, path, body string
+		write              bool
+	}{
+		{http.MethodGet, "/api/v1/todos" + inWorkspace, "", false},
+		{http.MethodGet, api, "", false},
+		{http.MethodGet, api + "/history", "", false},
+		{http.MethodGet, api + "/status_changes", "", false},
+		{http.MethodGet, api + "/comments", "", false},
+		{http.MethodGet, api + "/attachments", "", false},
+		{http.MethodGet, api + "/reminders", "", false},
+		{http.MethodGet, "/api/v1/trash" + inWorkspace, "", false},
+		{http.MethodPost, "/api/v1/todos", fmt.Sprintf(`{"content": "new", "workspaceId": %d}`, ws.ID), true},
+		{http.MethodPut, api, `{"content": "changed"}`, true},
+		{http.MethodPatch, api, `{"content": "changed"}`, true},
+		{http.MethodPost, api + "/skip", "", true},
+		{http.MethodPost, api + "/end_series", "", true},
+		{http.MethodPut, api + "/subtasks/order", `{"ids": []}`, true},
+		{http.MethodPost, api + "/move", `{"position": 0}`, true},
+		{http.MethodPut, fmt.Sprintf("%s/tags/%d", api, tag.ID), "", true},
+		{http.MethodDelete, fmt.Sprintf("%s/tags/%d", api, tag.ID), "", true},
+		{http.MethodPost, api + "/history/1/revert", "", true},
+		{http.MethodPost, api + "/comments", `{"body": "changed"}`, true},
+		{http.MethodPatch, fmt.Sprintf("/api/v1/comments/%d", comment.ID), `{"body": "changed"}`, true},
+		{http.MethodDelete, fmt.Sprintf("/api/v1/comments/%d", comment.ID), "", true},
+		{http.MethodPost, fmt.Sprintf("/api/v1/trash/%d/restore", todo.ID), "", true},
+		{http.MethodDelete, fmt.Sprintf("/api/v1/trash/%d", todo.ID), "", true},
+		{http.MethodDelete, "/api/v1/trash" + inWorkspace, "", true},
+		{http.MethodDelete, api, "", true},
+		{http.MethodPost, fmt.Sprintf("/todos/update/%d", todo.ID), `{"content": "changed"}`, true},
+		{http.MethodPost, fmt.Sprintf("/todos/delete/%d", todo.ID), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := viewer.do(tt.method, tt.path, tt.body)
+			if tt.write && w.Code != http.StatusForbidden {
+				t.Errorf("viewer status = %d, want %d; body %s", w.Code, http.StatusForbidden, w.Body)
+			}
+			if !tt.write && w.Code != http.StatusOK {
+				t.Errorf("viewer status = %d, want %d; body %s", w.Code, http.StatusOK, w.Body)
+			}
+			if w := outsider.do(tt.method, tt.path, tt.body); w.Code != http.StatusNotFound {
+				t.Errorf("outsider status = %d, want %d; body %s", w.Code, http.StatusNotFound, w.Body)
+			}
+		})
+	}
+
+	w := owner.do(http.MethodGet, api, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Content":"shared"`) {
+		t.Fatalf("todo changed by a viewer or outsider: %d %s", w.Code, w.Body)
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/api/v1/todos", fmt.Sprintf(`{"content": "new", "workspaceId": %d}`, ws.ID), http.StatusCreated},
+		{http.MethodPatch, api, `{"content": "edited"}`, http.StatusOK},
+		{http.MethodPost, api + "/comments", `{"body": "reply"}`, http.StatusCreated},
+		{http.MethodPost, fmt.Sprintf("/todos/update/%d", todo.ID), `{"content": "edited again"}`, http.StatusOK},
+		{http.MethodDelete, api, "", http.StatusNoContent},
+	} {
+		if w := editor.do(tt.method, tt.path, tt.body); w.Code != tt.want {
+			t.Errorf("editor %s %s status = %d, want %d; body %s", tt.method, tt.path, w.Code, tt.want, w.Body)
+		}
+	}
+}
+
+func TestWorkspaceOwnerOnlyRoutes(t *testing.T) {
+	router := newRouter()
+	owner, editor, outsider := newTestClient(t, router), newTestClient(t, router), newTestClient(t, router)
+	ws := owner.createWorkspace()
+	editor.joinWorkspace(owner, ws.ID, models.RoleEditor)
+	path := fmt.Sprintf("/api/v1/workspaces/%d", ws.ID)
+
+	tests := []struct {
+		method, path, body string
+	}{
+		{http.MethodPatch, path, `{"name": "Renamed"}`},
+		{http.MethodDelete, path, ""},
+		{http.MethodPost, path + "/invitations", fmt.Sprintf(`{"email": %q, "role": "viewer"}`, outsider.user.Email)},
+		{http.MethodPatch, fmt.Sprintf("%s/members/%d", path, editor.user.ID), `{"role": "owner"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if w := editor.do(tt.method, tt.path, tt.body); w.Code != http.StatusForbidden {
+				t.Errorf("editor status = %d, want %d; body %s", w.Code, http.StatusForbidden, w.Body)
+			}
+			if w := outsider.do(tt.method, tt.path, tt.body); w.Code != http.StatusNotFound {
+				t.Errorf("outsider status = %d, want %d; body %s", w.Code, http.StatusNotFound, w.Body)
+			}
+		})
+	}
+}
+
+func TestInvitationResponseDoesNotRevealAccounts(t *testing.T) {
+	router := newRouter()
+	owner, member := newTestClient(t, router), newTestClient(t, router)
+	ws := owner.createWorkspace()
+	path := fmt.Sprintf("/api/v1/workspaces/%d/invitations", ws.ID)
+
+	var bodies []map[string]interface{}
+	for _, email := range []string{member.user.Email, newTestEmail()} {
+		w := owner.do(http.MethodPost, path, fmt.Sprintf(`{"email": %q, "role": "viewer"}`, email))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("inviting %s status = %d, want %d; body %s", email, w.Code, http.StatusCreated, w.Body)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decoding %s: %v", w.Body, err)
+		}
+		bodies = append(bodies, body)
+	}
+	for key := range bodies[0] {
+		if _, ok := bodies[1][key]; !ok {
+			t.Errorf("only the invitation to an account has %q", key)
+		}
+	}
+	for key := range bodies[1] {
+		if _, ok := bodies[0][key]; !ok {
+			t.Errorf("only the invitation to an unknown address has %q", key)
+		}
+	}
+	if bodies[0]["status"] != "pending" || bodies[1]["status"] != "pending" {
+		t.Errorf("invitations = %v, want both pending", bodies)
+	}
+}
@@ -2,9 +2,13 @@ package controllers
 
 import (
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"todo_app/app/models"
 	"todo_app/config"
@@ -13,7 +17,7 @@ import (
 func enableCORS(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
 	if r.Method == "OPTIONS" {
@@ -23,6 +27,8 @@ func enableCORS(w http.ResponseWriter, r *http.Request) {
 }
 
 // CORSミドルウェア関数
+// It wraps the whole mux so preflight requests are answered before method
+// matching would reject OPTIONS with 405.
 func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		enableCORS(w, r)
@@ -38,38 +44,291 @@ func session(w http.ResponseWriter, r *http.Request) (sess models.Session, err e
 	cookie, err := r.Cookie("_cookie")
 	if err == nil {
 		sess = models.Session{UUID: cookie.Value}
-		if ok, _ := sess.CheckSession(); !ok {
+		if ok, checkErr := sess.CheckSession(); !ok {
+			if checkErr == models.ErrSessionExpired {
+				clearSessionCookie(w)
+				return sess, checkErr
+			}
 			err = fmt.Errorf("invalid session")
+		} else {
+			// Slide the cookie expiry along with the idle timeout.
+			setSessionCookie(w, sess)
 		}
 	}
 	return sess, err
 }
 
+func setSessionCookie(w http.ResponseWriter, sess models.Session) {
+	expires := sess.ExpiresAt()
+	http.SetCookie(w, &http.Cookie{
+		Name:     "_cookie",
+		Value:    sess.UUID,
+		HttpOnly: true,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		Secure:   false, // 開発環境
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   "_cookie",
+		Value:  "",
+		MaxAge: -1,
+		Path:   "/",
+	})
+}
+
+// clientIP is the address of the direct peer. X-Forwarded-For is ignored
+// because the server is not deployed behind a trusted proxy.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 var validPath = regexp.MustCompile("^/todos/(edit|update|delete)/([0-9]+)/?$")
 
 func parseURL(fn func(http.ResponseWriter, *http.Request, int)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		q := validPath.FindStringSubmatch(r.URL.Path)
 		if q == nil {
-			http.NotFound(w, r)
+			writeError(w, http.StatusNotFound, "Not found")
 			return
 		}
 		qi, err := strconv.Atoi(q[2])
 		if err != nil {
-			http.NotFound(w, r)
+			writeError(w, http.StatusNotFound, "Not found")
 			return
 		}
 		fn(w, r, qi)
 	}
 }
 
+// currentUser resolves the logged-in user for r, writing a 401 or 500 and
+// returning ok=false when there is none.
+func currentUser(w http.ResponseWriter, r *http.Request) (user models.User, sess models.Session, ok bool) {
+	sess, err := session(w, r)
+	if err != nil {
+		log.Printf("Session error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return user, sess, false
+	}
+	user, err = sess.GetUserBySession()
+	if err != nil {
+		log.Printf("GetUserBySession error on %s %s: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusInternalServerError, "User not found")
+		return user, sess, false
+	}
+	return user, sess, true
+}
+
+// withID passes the numeric {id} path value to fn, answering 404 otherwise.
+func withID(fn func(http.ResponseWriter, *http.Request, int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		fn(w, r, id)
+	}
+}
+
+// withTodoTag is withID for routes that also carry a numeric {tagId}.
+func withTodoTag(fn func(http.ResponseWriter, *http.Request, int, int)) http.HandlerFunc {
+	return withID(func(w http.ResponseWriter, r *http.Request, id int) {
+		tagID, err := strconv.Atoi(r.PathValue("tagId"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		fn(w, r, id, tagID)
+	})
+}
+
+// withTodoVersion is withID for routes that also carry a numeric {versionId}.
+func withTodoVersion(fn func(http.ResponseWriter, *http.Request, int, int)) http.HandlerFunc {
+	return withID(func(w http.ResponseWriter, r *http.Request, id int) {
+		versionID, err := strconv.Atoi(r.PathValue("versionId"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		fn(w, r, id, versionID)
+	})
+}
+
+// deprecated marks a legacy route and points clients at its replacement.
+func deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		next(w, r)
+	}
+}
+
 func StartMainServer() error {
-	http.HandleFunc("/signup", corsMiddleware(signup))
-	http.HandleFunc("/authenticate", corsMiddleware(authenticate))
-	http.HandleFunc("/todos", corsMiddleware(index))
-	http.HandleFunc("/logout", corsMiddleware(logout))
-	http.HandleFunc("/todos/save", corsMiddleware(todoSave))
-	http.HandleFunc("/todos/update/", corsMiddleware(parseURL(todoUpdate)))
-	http.HandleFunc("/todos/delete/", corsMiddleware(parseURL(todoDelete)))
-	return http.ListenAndServe(":"+config.Config.Port, nil)
+	models.StartSessionSweeper(config.Config.SessionSweepInterval)
+
+	models.RegisterDelivery(models.InboxDelivery{})
+	if config.Config.SMTPHost != "" {
+		models.RegisterDelivery(&models.SMTPDelivery{
+			Host:     config.Config.SMTPHost,
+			Port:     config.Config.SMTPPort,
+			From:     config.Config.SMTPFrom,
+			Username: config.Config.SMTPUsername,
+			Password: config.Config.SMTPPassword,
+		})
+	}
+	models.StartReminderScheduler(config.Config.ReminderPollInterval, config.Config.ReminderMaxAttempts)
+	models.ConfigureAttachments(&models.LocalBlobStore{Dir: config.Config.AttachmentDir}, models.AttachmentLimits{
+		MaxSize:      config.Config.AttachmentMaxSize,
+		UserQuota:    config.Config.AttachmentQuota,
+		AllowedTypes: config.Config.AttachmentAllowedTypes,
+	})
+	if config.Config.TrashRetention > 0 {
+		models.StartTrashPurger(config.Config.TrashPurgeInterval, config.Config.TrashRetention)
+	}
+
+	return http.ListenAndServe(":"+config.Config.Port, newRouter())
+}
+
+// newRouter maps every route to its handler, without starting any of the
+// background jobs.
+func newRouter() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/signup", signup)
+	mux.HandleFunc("/authenticate", authenticate)
+	mux.HandleFunc("/logout", logout)
+	mux.HandleFunc("GET /sessions", sessionList)
+	mux.HandleFunc("POST /sessions/revoke/{id}", withID(sessionRevoke))
+	mux.HandleFunc("POST /sessions/revoke_others", sessionRevokeOthers)
+
+	mux.HandleFunc("GET /api/v1/me", apiMeGet)
+	mux.HandleFunc("PATCH /api/v1/me", apiMePatch)
+
+	mux.HandleFunc("GET /api/v1/todos", apiTodoList)
+	mux.HandleFunc("POST /api/v1/todos", apiTodoCreate)
+	mux.HandleFunc("GET /api/v1/todos/{id}", withID(apiTodoGet))
+	mux.HandleFunc("PUT /api/v1/todos/{id}", withID(apiTodoReplace))
+	mux.HandleFunc("PATCH /api/v1/todos/{id}", withID(apiTodoPatch))
+	mux.HandleFunc("DELETE /api/v1/todos/{id}", withID(apiTodoDelete))
+	mux.HandleFunc("POST /api/v1/todos/{id}/skip", withID(apiTodoSkip))
+	mux.HandleFunc("POST /api/v1/todos/{id}/end_series", withID(apiTodoEndSeries))
+	mux.HandleFunc("PUT /api/v1/todos/{id}/subtasks/order", withID(apiTodoSubtaskOrder))
+	mux.HandleFunc("POST /api/v1/todos/{id}/move", withID(apiTodoMove))
+	mux.HandleFunc("PUT /api/v1/todos/{id}/tags/{tagId}", withTodoTag(apiTodoTag))
+	mux.HandleFunc("DELETE /api/v1/todos/{id}/tags/{tagId}", withTodoTag(apiTodoUntag))
+
+	mux.HandleFunc("GET /api/v1/todos/{id}/history", withID(apiTodoHistory))
+	mux.HandleFunc("POST /api/v1/todos/{id}/history/{versionId}/revert", withTodoVersion(apiTodoRevert))
+	mux.HandleFunc("GET /api/v1/todos/{id}/status_changes", withID(apiTodoStatusChanges))
+	mux.HandleFunc("GET /api/v1/activity", apiActivityList)
+
+	mux.HandleFunc("GET /api/v1/trash", apiTrashList)
+	mux.HandleFunc("DELETE /api/v1/trash", apiTrashEmpty)
+	mux.HandleFunc("POST /api/v1/trash/{id}/restore", withID(apiTrashRestore))
+	mux.HandleFunc("DELETE /api/v1/trash/{id}", withID(apiTrashPurge))
+
+	mux.HandleFunc("GET /api/v1/projects", apiProjectList)
+	mux.HandleFunc("POST /api/v1/projects", apiProjectCreate)
+	mux.HandleFunc("GET /api/v1/projects/{id}", withID(apiProjectGet))
+	mux.HandleFunc("PATCH /api/v1/projects/{id}", withID(apiProjectPatch))
+	mux.HandleFunc("DELETE /api/v1/projects/{id}", withID(apiProjectDelete))
+	mux.HandleFunc("GET /api/v1/projects/{id}/todos", withID(apiProjectTodos))
+	mux.HandleFunc("GET /api/v1/projects/{id}/workflow", withID(apiWorkflowGet))
+	mux.HandleFunc("PUT /api/v1/projects/{id}/workflow", withID(apiWorkflowPut))
+	mux.HandleFunc("DELETE /api/v1/projects/{id}/workflow", withID(apiWorkflowDelete))
+	mux.HandleFunc("GET /api/v1/projects/{id}/board", withID(apiProjectBoard))
+	mux.HandleFunc("GET /api/v1/board", apiBoard)
+
+	mux.HandleFunc("GET /api/v1/todos/{id}/comments", withID(apiCommentList))
+	mux.HandleFunc("POST /api/v1/todos/{id}/comments", withID(apiCommentCreate))
+	mux.HandleFunc("PATCH /api/v1/comments/{id}", withID(apiCommentPatch))
+	mux.HandleFunc("DELETE /api/v1/comments/{id}", withID(apiCommentDelete))
+
+	mux.HandleFunc("GET /api/v1/todos/{id}/attachments", withID(apiAttachmentList))
+	mux.HandleFunc("POST /api/v1/todos/{id}/attachments", withID(apiAttachmentCreate))
+	mux.HandleFunc("GET /api/v1/attachments/{id}", withID(apiAttachmentDownload))
+	mux.HandleFunc("DELETE /api/v1/attachments/{id}", withID(apiAttachmentDelete))
+
+	mux.HandleFunc("GET /api/v1/todos/{id}/reminders", withID(apiReminderList))
+	mux.HandleFunc("POST /api/v1/todos/{id}/reminders", withID(apiReminderCreate))
+	mux.HandleFunc("DELETE /api/v1/reminders/{id}", withID(apiReminderDelete))
+	mux.HandleFunc("GET /api/v1/notifications", apiNotificationList)
+	mux.HandleFunc("POST /api/v1/notifications/{id}/read", withID(apiNotificationRead))
+	mux.HandleFunc("POST /api/v1/notifications/read_all", apiNotificationReadAll)
+
+	mux.HandleFunc("GET /api/v1/tags", apiTagList)
+	mux.HandleFunc("POST /api/v1/tags", apiTagCreate)
+	mux.HandleFunc("GET /api/v1/tags/{id}", withID(apiTagGet))
+	mux.HandleFunc("PATCH /api/v1/tags/{id}", withID(apiTagPatch))
+	mux.HandleFunc("DELETE /api/v1/tags/{id}", withID(apiTagDelete))
+	mux.HandleFunc("POST /api/v1/tags/{id}/merge", withID(apiTagMerge))
+
+	mux.HandleFunc("GET /api/v1/workspaces", apiWorkspaceList)
+	mux.HandleFunc("POST /api/v1/workspaces", apiWorkspaceCreate)
+	mux.HandleFunc("GET /api/v1/workspaces/{id}", withID(apiWorkspaceGet))
+	mux.HandleFunc("PATCH /api/v1/workspaces/{id}", withID(apiWorkspacePatch))
+	mux.HandleFunc("DELETE /api/v1/workspaces/{id}", withID(apiWorkspaceDelete))
+	mux.HandleFunc("GET /api/v1/workspaces/{id}/members", withID(apiMemberList))
+	mux.HandleFunc("PATCH /api/v1/workspaces/{id}/members/{userId}", withWorkspaceMember(apiMemberPatch))
+	mux.HandleFunc("DELETE /api/v1/workspaces/{id}/members/{userId}", withWorkspaceMember(apiMemberDelete))
+	mux.HandleFunc("GET /api/v1/workspaces/{id}/invitations", withID(apiWorkspaceInvitationList))
+	mux.HandleFunc("POST /api/v1/workspaces/{id}/invitations", withID(apiInvitationCreate))
+	mux.HandleFunc("GET /api/v1/invitations", apiInvitationList)
+	mux.HandleFunc("POST /api/v1/invitations/{id}/accept", withID(apiInvitationAccept))
+	mux.HandleFunc("POST /api/v1/invitations/{id}/decline", withID(apiInvitationDecline))
+	mux.HandleFunc("DELETE /api/v1/invitations/{id}", withID(apiInvitationRevoke))
+
+	// Legacy routes, kept until the frontend has moved to /api/v1.
+	mux.HandleFunc("/todos", deprecated("/api/v1/todos", index))
+	mux.HandleFunc("/todos/save", deprecated("/api/v1/todos", todoSave))
+	mux.HandleFunc("/todos/update/", deprecated("/api/v1/todos/{id}", parseURL(todoUpdate)))
+	mux.HandleFunc("/todos/delete/", deprecated("/api/v1/todos/{id}", parseURL(todoDelete)))
+
+	return corsMiddleware(apiFallback(mux))
+}
+
+// apiFallback answers /api/v1 requests that match no route with the JSON
+// error envelope, keeping the status of the mux's own plain-text 404 or 405
+// and its Allow header.
+func apiFallback(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" || !strings.HasPrefix(r.URL.Path, "/api/v1/") {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{header: http.Header{}}
+		h.ServeHTTP(rec, r)
+		if allow := rec.header.Get("Allow"); allow != "" {
+			w.Header().Set("Allow", allow)
+		}
+		writeError(w, rec.status, http.StatusText(rec.status))
+	}
+}
+
+// statusRecorder keeps the status and headers a handler writes and drops
+// the body.
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (rec *statusRecorder) Header() http.Header { return rec.header }
+
+func (rec *statusRecorder) WriteHeader(status int) { rec.status = status }
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return len(b), nil
 }
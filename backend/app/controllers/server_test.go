@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestUnroutedAPIRequestsUseErrorEnvelope(t *testing.T) {
+	c := newTestClient(t, newRouter())
+
+	tests := []struct {
+		method, path string
+		status       int
+		code, allow  string
+	}{
+		{http.MethodGet, "/api/v1/nothing", http.StatusNotFound, "not_found", ""},
+		{http.MethodPost, "/api/v1/todos/1", http.StatusMethodNotAllowed, "method_not_allowed", "DELETE, GET, HEAD, PATCH, PUT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := c.do(tt.method, tt.path, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+			var body struct {
+				Status string   `json:"status"`
+				Error  apiError `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body is not JSON: %v: %s", err, w.Body)
+			}
+			if body.Status != "error" || body.Error.Code != tt.code {
+				t.Errorf("body = %+v, want status error and code %s", body, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package models
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const MaxAttachmentFilenameLen = 255
+
+// Attachment is a file attached to a todo. Its content lives in the
+// BlobStore under SHA256.
+type Attachment struct {
+	ID          int       `json:"id"`
+	TodoID      int       `json:"todo_id"`
+	UserID      int       `json:"user_id"` // who uploaded it
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AttachmentLimits restrict uploads. Sizes are in bytes.
+type AttachmentLimits struct {
+	MaxSize      int64    // per file
+	UserQuota    int64    // all of a user's attachments together, 0 for no quota
+	AllowedTypes []string // media types such as "application/pdf" or "image/*"; empty allows any
+}
+
+func (l AttachmentLimits) allows(mediaType string) bool {
+	if len(l.AllowedTypes) == 0 {
+		return true
+	}
+	for _, allowed := range l.AllowedTypes {
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	attachmentStore  BlobStore
+	attachmentLimits AttachmentLimits
+
+	// blobLock keeps a blob from being deleted as unreferenced while an
+	// upload of the same content is between storing it and inserting its
+	// row. Uploads share it; deletions take it exclusively.
+	blobLock sync.RWMutex
+)
+
+// ConfigureAttachments sets where attachments are stored and how large
+// they may get. Call it before serving requests.
+func ConfigureAttachments(store BlobStore, limits AttachmentLimits) {
+	attachmentStore = store
+	attachmentLimits = limits
+}
+
+const attachmentColumns = `id, todo_id, user_id, filename, content_type, size, blob_key, created_at`
+
+func attachmentFields(a *Attachment) []interface{} {
+	return []interface{}{&a.ID, &a.TodoID, &a.UserID, &a.Filename, &a.ContentType, &a.Size, &a.SHA256, &a.CreatedAt}
+}
+
+// AttachmentUsage returns how many bytes the user's uploads take up against
+// the quota, trashed todos included. Attachments count against the quota of
+// whoever uploaded them, on workspace todos too.
+func (u *User) AttachmentUsage() (used int64, err error) {
+	err = Db.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = ?`, u.actor()).Scan(&used)
+	return used, err
+}
+
+// AttachmentQuota is the per-user limit, 0 for none.
+func AttachmentQuota() int64 {
+	return attachmentLimits.UserQuota
+}
+
+// GetAttachments lists the attachments of one of the user's todos, oldest
+// first, whoever uploaded them.
+func (u *User) GetAttachments(todoID int) (attachments []Attachment, err error) {
+	if _, err = u.GetTodo(todoID); err != nil {
+		return nil, err
+	}
+	cmd := `SELECT ` + attachmentColumns + ` FROM attachments WHERE todo_id = ? ORDER BY id`
+	rows, err := Db.Query(cmd, todoID)
+	if err != nil {
+		log.Println("GetAttachments error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Attachment
+		if err = rows.Scan(attachmentFields(&a)...); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+func (u *User) GetAttachment(id int) (a Attachment, err error) {
+	cmd := `SELECT ` + attachmentColumns + ` FROM attachments
+	WHERE id = ? AND todo_id IN (SELECT id FROM todos WHERE user_id = ? AND ` + inWorkspace("todos") + ` AND deleted_at IS NULL)`
+	err = Db.QueryRow(cmd, id, u.ID, u.workspaceID).Scan(attachmentFields(&a)...)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("GetAttachment error:", err)
+	}
+	return a, err
+}
+
+// errAttachmentTooLarge aborts a BlobStore.Put that read past its limit.
+var errAttachmentTooLarge = errors.New("attachment too large")
+
+// capReader fails with errAttachmentTooLarge once more than max bytes have
+// been read.
+type capReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (c *capReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.read += int64(n)
+	if c.read > c.max {
+		return n, errAttachmentTooLarge
+	}
+	return n, err
+}
+
+// CreateAttachment stores the content read from r as an attachment of one
+// of the user's todos. The content type is sniffed from the content rather
+// than trusted from the client. Files over the size limit, of types that
+// are not allowed, or that would take the user over the quota are reported
+// as a *ValidationError.
+func (u *User) CreateAttachment(todoID int, filename string, r io.Reader) (a Attachment, err error) {
+	if _, err = u.GetTodo(todoID); err != nil {
+		return a, err
+	}
+	invalid := func(message string) error {
+		return &ValidationError{Fields: map[string]string{"file": message}}
+	}
+	filename = cleanFilename(filename)
+	if filename == "" {
+		return a, invalid("must have a file name")
+	}
+	if utf8.RuneCountInString(filename) > MaxAttachmentFilenameLen {
+		return a, invalid(fmt.Sprintf("file name must be at most %d characters", MaxAttachmentFilenameLen))
+	}
+
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return a, err
+	}
+	if len(head) == 0 {
+		return a, invalid("must not be empty")
+	}
+	contentType := http.DetectContentType(head)
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !attachmentLimits.allows(mediaType) {
+		return a, invalid(fmt.Sprintf("files of type %s are not allowed", mediaType))
+	}
+
+	limit, quotaBound := attachmentLimits.MaxSize, false
+	if attachmentLimits.UserQuota > 0 {
+		used, err := u.AttachmentUsage()
+		if err != nil {
+			return a, err
+		}
+		if left := attachmentLimits.UserQuota - used; left < limit {
+			limit, quotaBound = left, true
+		}
+	}
+
+	blobLock.RLock()
+	key, size, err := attachmentStore.Put(&capReader{r: br, max: limit})
+	var id int64
+	if err == nil {
+		id, err = u.insertAttachment(todoID, filename, contentType, size, key)
+	}
+	blobLock.RUnlock()
+	if err != nil && key != "" {
+		// Nothing refers to the stored content unless an identical file
+		// was already attached.
+		if deleteErr := deleteOrphanBlobs([]string{key}); deleteErr != nil {
+			return a, deleteErr
+		}
+	}
+	if errors.Is(err, errAttachmentTooLarge) && !quotaBound {
+		return a, invalid(fmt.Sprintf("must be at most %d bytes", attachmentLimits.MaxSize))
+	}
+	if errors.Is(err, errAttachmentTooLarge) || errors.Is(err, errAttachmentQuota) {
+		return a, invalid(fmt.Sprintf("would exceed your storage quota of %d bytes", attachmentLimits.UserQuota))
+	}
+	if err != nil {
+		log.Println("CreateAttachment error:", err)
+		return a, err
+	}
+	return u.GetAttachment(int(id))
+}
+
+// errAttachmentQuota is returned by insertAttachment when the upload no
+// longer fits the user's quota.
+var errAttachmentQuota = errors.New("attachment quota exceeded")
+
+// insertAttachment adds the row for a stored upload. The quota is checked
+// again in the same statement, so concurrent uploads that each fit on their
+// own cannot exceed it together.
+func (u *User) insertAttachment(todoID int, filename, contentType string, size int64, key string) (id int64, err error) {
+	cmd := `INSERT INTO attachments (todo_id, user_id, filename, content_type, size, blob_key, created_at)
+	SELECT ?, ?, ?, ?, ?, ?, ?
+	WHERE ? = 0 OR (SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = ?) + ? <= ?`
+	quota := attachmentLimits.UserQuota
+	result, err := Db.Exec(cmd, todoID, u.actor(), filename, contentType, size, key, time.Now().UTC(),
+		quota, u.actor(), size, quota)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, errAttachmentQuota
+	}
+	return result.LastInsertId()
+}
+
+// cleanFilename keeps only the last path element of a client-supplied file
+// name and drops control characters.
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// OpenAttachment returns one of the user's attachments with a reader for
+// its content, which the caller must close.
+func (u *User) OpenAttachment(id int) (a Attachment, content io.ReadCloser, err error) {
+	if a, err = u.GetAttachment(id); err != nil {
+		return a, nil, err
+	}
+	content, err = attachmentStore.Open(a.SHA256)
+	if err != nil {
+		log.Println("OpenAttachment error:", err)
+	}
+	return a, content, err
+}
+
+// DeleteAttachment removes one of the user's attachments, and its content
+// once no other attachment shares it.
+func (u *User) DeleteAttachment(id int) error {
+	a, err := u.GetAttachment(id)
+	if err != nil {
+		return err
+	}
+	if _, err = Db.Exec(`DELETE FROM attachments WHERE id = ?`, id); err != nil {
+		log.Println("DeleteAttachment error:", err)
+		return err
+	}
+	return deleteOrphanBlobs([]string{a.SHA256})
+}
+
+// deleteOrphanBlobs removes the blobs with the given keys that no
+// attachment refers to any more.
+func deleteOrphanBlobs(keys []string) error {
+	blobLock.Lock()
+	defer blobLock.Unlock()
+	for _, key := range keys {
+		var refs int
+		if err := Db.QueryRow(`SELECT COUNT(*) FROM attachments WHERE blob_key = ?`, key).Scan(&refs); err != nil {
+			return err
+		}
+		if refs > 0 {
+			continue
+		}
+		if err := attachmentStore.Delete(key); err != nil {
+			log.Println("deleteOrphanBlobs error:", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"todo_app/config"
+)
+
+// barrierBlobStore holds every Put until all expected uploads are
+// streaming, so that they all get past the checks made before it.
+type barrierBlobStore struct {
+	*LocalBlobStore
+	arrived *sync.WaitGroup
+}
+
+func (s barrierBlobStore) Put(r io.Reader) (key string, size int64, err error) {
+	s.arrived.Done()
+	s.arrived.Wait()
+	return s.LocalBlobStore.Put(r)
+}
+
+func TestAttachmentQuotaHoldsForConcurrentUploads(t *testing.T) {
+	// Each upload fits the quota on its own, but no two fit together.
+	const uploads = 8
+	dir := filepath.Join(config.Config.AttachmentDir, t.Name())
+	var arrived sync.WaitGroup
+	arrived.Add(uploads)
+	ConfigureAttachments(barrierBlobStore{&LocalBlobStore{Dir: dir}, &arrived}, AttachmentLimits{MaxSize: 100, UserQuota: 10})
+	defer ConfigureAttachments(nil, AttachmentLimits{})
+
+	u := newTestUser(t)
+	todo := newTestTodo(t, u, "uploads")
+
+	errs := make([]error, uploads)
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = u.CreateAttachment(todo.ID, "notes.txt", strings.NewReader(fmt.Sprintf("upload %d", i)))
+		}(i)
+	}
+	wg.Wait()
+
+	stored := 0
+	for i, err := range errs {
+		var verr *ValidationError
+		switch {
+		case err == nil:
+			stored++
+		case !errors.As(err, &verr):
+			t.Errorf("upload %d err = %v, want a validation error", i, err)
+		}
+	}
+	if stored != 1 {
+		t.Errorf("%d uploads stored, want 1", stored)
+	}
+	if used, err := u.AttachmentUsage(); err != nil || used > 10 {
+		t.Errorf("usage = %d, %v, want at most the quota of 10", used, err)
+	}
+
+	// The content of rejected uploads is not left behind.
+	var blobs int
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			blobs++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("walking %s: %v", dir, err)
+	}
+	if blobs != stored {
+		t.Errorf("%d blobs stored, want %d", blobs, stored)
+	}
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BlobStore keeps attachment contents, addressed by the hex SHA-256 of
+// their bytes, so the same file uploaded twice is stored once.
+type BlobStore interface {
+	// Put stores everything read from r and returns its key and size. An
+	// error from r aborts the write and is returned as is.
+	Put(r io.Reader) (key string, size int64, err error)
+	Open(key string) (io.ReadCloser, error)
+	// Delete removes a blob; deleting a missing blob is not an error.
+	Delete(key string) error
+}
+
+var errBadBlobKey = errors.New("malformed blob key")
+
+// LocalBlobStore keeps blobs as files under Dir, fanned out into
+// subdirectories by the first two characters of the key.
+type LocalBlobStore struct {
+	Dir string
+}
+
+func (s *LocalBlobStore) path(key string) (string, error) {
+	if len(key) != sha256.Size*2 {
+		return "", errBadBlobKey
+	}
+	if _, err := hex.DecodeString(key); err != nil {
+		return "", errBadBlobKey
+	}
+	return filepath.Join(s.Dir, key[:2], key), nil
+}
+
+func (s *LocalBlobStore) Put(r io.Reader) (key string, size int64, err error) {
+	if err = os.MkdirAll(s.Dir, 0o750); err != nil {
+		return "", 0, err
+	}
+	// Write to a temporary file first: the key is only known once the
+	// whole content has been read.
+	tmp, err := os.CreateTemp(s.Dir, "upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	key = hex.EncodeToString(h.Sum(nil))
+	path, _ := s.path(key)
+	if _, err = os.Stat(path); err == nil {
+		return key, size, nil // already stored
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return "", 0, err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+	return key, size, nil
+}
+
+func (s *LocalBlobStore) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *LocalBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"todo_app/utils"
+)
+
+const (
+	MaxCommentLength    = 10000
+	DefaultCommentLimit = 50
+	MaxCommentLimit     = 200
+)
+
+// Comment is one message in the discussion of a todo. Only its author can
+// edit or delete it.
+type Comment struct {
+	ID         int    `json:"id"`
+	TodoID     int    `json:"todo_id"`
+	AuthorID   int    `json:"author_id"`
+	AuthorName string `json:"author_name"`
+	Body       string `json:"body"` // Markdown
+	// BodyHTML is only filled in by RenderBody, like Todo.DescriptionHTML.
+	BodyHTML  string     `json:"body_html,omitempty"`
+	Edited    bool       `json:"edited"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at"` // nil until the body is first changed
+}
+
+// RenderBody fills BodyHTML with the sanitised HTML rendering of the body.
+func (c *Comment) RenderBody() {
+	c.BodyHTML = utils.RenderMarkdown(c.Body)
+}
+
+func validateCommentBody(body string) error {
+	v := &ValidationError{}
+	if strings.TrimSpace(body) == "" {
+		v.Add("body", "must not be empty")
+	} else if utf8.RuneCountInString(body) > MaxCommentLength {
+		v.Add("body", fmt.Sprintf("must be at most %d characters", MaxCommentLength))
+	}
+	return v.Err()
+}
+
+const commentColumns = `comments.id, comments.todo_id, comments.author_id, COALESCE(users.name, ''),
+		comments.body, comments.created_at, comments.edited_at`
+
+const commentTables = `comments LEFT JOIN users ON users.id = comments.author_id`
+
+func scanComment(row interface{ Scan(...interface{}) error }, c *Comment) error {
+	err := row.Scan(&c.ID, &c.TodoID, &c.AuthorID, &c.AuthorName, &c.Body, &c.CreatedAt, &c.EditedAt)
+	c.Edited = c.EditedAt != nil
+	return err
+}
+
+// GetComments returns the comments on a todo in the user's space, oldest
+// first. A non-zero after only returns comments newer than that comment
+// id, for paging.
+func (u *User) GetComments(todoID, after, limit int) (comments []Comment, err error) {
+	if limit < 1 || limit > MaxCommentLimit {
+		return nil, &ValidationError{Fields: map[string]string{"limit": fmt.Sprintf("must be between 1 and %d", MaxCommentLimit)}}
+	}
+	if _, err = u.GetTodo(todoID); err != nil {
+		return nil, err
+	}
+	cmd := `SELECT ` + commentColumns + ` FROM ` + commentTables + `
+	WHERE comments.todo_id = ? AND comments.id > ? ORDER BY comments.id LIMIT ?`
+	rows, err := Db.Query(cmd, todoID, after, limit)
+	if err != nil {
+		log.Println("GetComments error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Comment
+		if err = scanComment(rows, &c); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// getComment returns a comment on a todo the user can see.
+func (u *User) getComment(id int) (c Comment, err error) {
+	cmd := `SELECT ` + commentColumns + ` FROM ` + commentTables + `
+	JOIN todos ON todos.id = comments.todo_id
+	WHERE comments.id = ? AND todos.user_id = ? AND ` + inWorkspace("todos") + ` AND todos.deleted_at IS NULL`
+	err = scanComment(Db.QueryRow(cmd, id, u.ID, u.workspaceID), &c)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("getComment error:", err)
+	}
+	return c, err
+}
+
+// CreateComment adds a comment by the user to a todo in their space. In a
+// workspace the author is the member, not the owner.
+func (u *User) CreateComment(todoID int, body string) (c Comment, err error) {
+	if err = validateCommentBody(body); err != nil {
+		return c, err
+	}
+	todo, err := u.GetTodo(todoID)
+	if err != nil {
+		return c, err
+	}
+	cmd := `INSERT INTO comments (todo_id, user_id, author_id, body, created_at) VALUES (?, ?, ?, ?, ?)`
+	result, err := Db.Exec(cmd, todoID, todo.UserID, u.actor(), body, time.Now().UTC())
+	if err != nil {
+		log.Println("CreateComment error:", err)
+		return c, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return c, err
+	}
+	return u.getComment(int(id))
+}
+
+// UpdateComment changes the body of one of the user's own comments and
+// marks it as edited. Comments by others are reported as sql.ErrNoRows.
+func (u *User) UpdateComment(id int, body string) (c Comment, err error) {
+	if err = validateCommentBody(body); err != nil {
+		return c, err
+	}
+	if c, err = u.getComment(id); err != nil {
+		return c, err
+	}
+	if c.AuthorID != u.actor() {
+		return c, sql.ErrNoRows
+	}
+	if body == c.Body {
+		return c, nil
+	}
+	cmd := `UPDATE comments SET body = ?, edited_at = ? WHERE id = ? AND author_id = ?`
+	if _, err = Db.Exec(cmd, body, time.Now().UTC(), id, u.actor()); err != nil {
+		log.Println("UpdateComment error:", err)
+		return c, err
+	}
+	return u.getComment(id)
+}
+
+// DeleteComment removes one of the user's own comments.
+func (u *User) DeleteComment(id int) error {
+	c, err := u.getComment(id)
+	if err != nil {
+		return err
+	}
+	if c.AuthorID != u.actor() {
+		return sql.ErrNoRows
+	}
+	if _, err = Db.Exec(`DELETE FROM comments WHERE id = ? AND author_id = ?`, id, u.actor()); err != nil {
+		log.Println("DeleteComment error:", err)
+		return err
+	}
+	return nil
+}
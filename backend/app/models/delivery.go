@@ -0,0 +1,99 @@
+package models
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Delivery sends fired reminders through one channel.
+type Delivery interface {
+	Channel() string
+	Deliver(msg ReminderMessage) error
+}
+
+// ReminderMessage is what a Delivery sends when a reminder fires.
+type ReminderMessage struct {
+	Reminder Reminder
+	User     User
+	Todo     Todo
+	Subject  string
+	Body     string
+}
+
+func newReminderMessage(reminder Reminder, user User, todo Todo) ReminderMessage {
+	body := fmt.Sprintf("%q is due on %s.", todo.Content, todo.DueDate)
+	if todo.Description != "" {
+		body += "\n\n" + todo.Description
+	}
+	return ReminderMessage{
+		Reminder: reminder,
+		User:     user,
+		Todo:     todo,
+		Subject:  "Reminder: " + todo.Content,
+		Body:     body,
+	}
+}
+
+// reminderDeliveries maps channel names to the registered deliveries.
+var reminderDeliveries = map[string]Delivery{}
+
+// RegisterDelivery makes d available as a reminder channel. Call it before
+// StartReminderScheduler.
+func RegisterDelivery(d Delivery) {
+	reminderDeliveries[d.Channel()] = d
+}
+
+const (
+	InboxChannel = "inbox"
+	EmailChannel = "email"
+)
+
+// InboxDelivery stores reminders as in-app notifications.
+type InboxDelivery struct{}
+
+func (InboxDelivery) Channel() string { return InboxChannel }
+
+func (InboxDelivery) Deliver(msg ReminderMessage) error {
+	cmd := `INSERT INTO notifications (user_id, todo_id, reminder_id, title, body, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := Db.Exec(cmd, msg.User.ID, msg.Todo.ID, msg.Reminder.ID, msg.Subject, msg.Body, time.Now().UTC())
+	return err
+}
+
+// SMTPDelivery emails reminders to the user's address. Username may be
+// empty for relays that do not require authentication, such as a local
+// development mail catcher.
+type SMTPDelivery struct {
+	Host     string
+	Port     string
+	From     string
+	Username string
+	Password string
+}
+
+func (*SMTPDelivery) Channel() string { return EmailChannel }
+
+func (d *SMTPDelivery) Deliver(msg ReminderMessage) error {
+	var auth smtp.Auth
+	if d.Username != "" {
+		auth = smtp.PlainAuth("", d.Username, d.Password, d.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(d.Host, d.Port), auth, d.From, []string{msg.User.Email}, d.format(msg))
+}
+
+func (d *SMTPDelivery) format(msg ReminderMessage) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", d.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.User.Email)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+type HistoryAction string
+
+const (
+	HistoryCreated  HistoryAction = "created"
+	HistoryUpdated  HistoryAction = "updated"
+	HistoryReverted HistoryAction = "reverted" // updated back to an earlier version
+	HistoryDeleted  HistoryAction = "deleted"  // moved to the trash
+	HistoryRestored HistoryAction = "restored" // taken out of the trash
+)
+
+// FieldChange is one field of a todo before and after a change. Old is nil
+// on creation.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// HistoryEntry is one append-only record of what happened to a todo, by
+// whom and when.
+type HistoryEntry struct {
+	ID        int           `json:"id"`
+	TodoID    int           `json:"todo_id"`
+	UserID    int           `json:"user_id"`
+	ActorID   int           `json:"actor_id"`
+	ActorName string        `json:"actor_name"`
+	Action    HistoryAction `json:"action"`
+	Changes   []FieldChange `json:"changes"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 500
+)
+
+// historyFields lists the tracked fields of t in a fixed order. Project and
+// parent are ids, nil when unset; everything else is a string.
+func historyFields(t *Todo) []FieldChange {
+	id := func(p *int) interface{} {
+		if p == nil {
+			return nil
+		}
+		return *p
+	}
+	return []FieldChange{
+		{Field: "content", New: t.Content},
+		{Field: "description", New: t.Description},
+		{Field: "priority", New: string(t.Priority)},
+		{Field: "status", New: string(t.Status)},
+		{Field: "due_date", New: t.DueDate},
+		{Field: "due_time", New: t.DueTime},
+		{Field: "project_id", New: id(t.ProjectID)},
+		{Field: "parent_id", New: id(t.ParentID)},
+		{Field: "recurrence", New: t.Recurrence},
+	}
+}
+
+// diffTodos returns the tracked fields that differ between before and
+// after. A nil before lists every field, as on creation.
+func diffTodos(before, after *Todo) []FieldChange {
+	changes := []FieldChange{}
+	afterFields := historyFields(after)
+	if before == nil {
+		return afterFields
+	}
+	for i, old := range historyFields(before) {
+		if old.New != afterFields[i].New {
+			changes = append(changes, FieldChange{Field: old.Field, Old: old.New, New: afterFields[i].New})
+		}
+	}
+	return changes
+}
+
+// recordHistory appends an entry for a todo owned by userID and stamps the
+// todo with the time of the change, see stampTodo. Updates that changed no
+// tracked field are not recorded.
+func recordHistory(todoID, userID, actorID int, action HistoryAction, changes []FieldChange) error {
+	if changes == nil {
+		changes = []FieldChange{}
+	}
+	if len(changes) == 0 && (action == HistoryUpdated || action == HistoryReverted) {
+		return nil
+	}
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	cmd := `INSERT INTO todo_history (todo_id, user_id, actor_id, action, changes, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+	if _, err = Db.Exec(cmd, todoID, userID, actorID, action, string(b), now); err != nil {
+		log.Println("recordHistory error:", err)
+		return err
+	}
+	return stampTodo(todoID, userID, actorID, changes, now)
+}
+
+// recordTodoChange records the difference between two states of the same
+// todo, made by u, and refreshes the timestamps of after to match.
+func (u *User) recordTodoChange(action HistoryAction, before, after *Todo) error {
+	if err := recordHistory(after.ID, after.UserID, u.actor(), action, diffTodos(before, after)); err != nil {
+		return err
+	}
+	cmd := `SELECT updated_at, completed_at FROM todos WHERE id = ?`
+	return Db.QueryRow(cmd, after.ID).Scan(&after.UpdatedAt, &after.CompletedAt)
+}
+
+const historyColumns = `todo_history.id, todo_history.todo_id, todo_history.user_id, todo_history.actor_id,
+		COALESCE(users.name, ''), todo_history.action, todo_history.changes, todo_history.created_at`
+
+const historyTables = `todo_history LEFT JOIN users ON users.id = todo_history.actor_id`
+
+func scanHistoryEntry(row interface{ Scan(...interface{}) error }, e *HistoryEntry) error {
+	var changes string
+	err := row.Scan(&e.ID, &e.TodoID, &e.UserID, &e.ActorID, &e.ActorName, &e.Action, &changes, &e.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(changes), &e.Changes)
+}
+
+func queryHistory(cmd string, args ...interface{}) (entries []HistoryEntry, err error) {
+	rows, err := Db.Query(cmd, args...)
+	if err != nil {
+		log.Println("queryHistory error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e HistoryEntry
+		if err = scanHistoryEntry(rows, &e); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetTodoHistory returns the history of one of the todos in the user's
+// space, newest first. Trashed todos keep their history until they are
+// purged.
+func (u *User) GetTodoHistory(todoID int) (entries []HistoryEntry, err error) {
+	var count int
+	cmd := `SELECT COUNT(*) FROM todos WHERE id = ? AND user_id = ? AND ` + inWorkspace("todos")
+	if err = Db.QueryRow(cmd, todoID, u.ID, u.workspaceID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, sql.ErrNoRows
+	}
+	cmd = `SELECT ` + historyColumns + ` FROM ` + historyTables + `
+	WHERE todo_history.todo_id = ? ORDER BY todo_history.id DESC`
+	return queryHistory(cmd, todoID)
+}
+
+// GetActivity returns the history of all the todos in the user's space,
+// newest first, whoever made the changes.
+// A non-zero before only returns entries older than that entry id, for
+// paging.
+func (u *User) GetActivity(before, limit int) (entries []HistoryEntry, err error) {
+	if limit < 1 || limit > MaxHistoryLimit {
+		return nil, &ValidationError{Fields: map[string]string{"limit": "must be between 1 and 500"}}
+	}
+	cmd := `SELECT ` + historyColumns + ` FROM ` + historyTables + `
+	WHERE todo_history.user_id = ? AND (? = 0 OR todo_history.id < ?)
+		AND todo_history.todo_id IN (SELECT id FROM todos WHERE user_id = ? AND ` + inWorkspace("todos") + `)
+	ORDER BY todo_history.id DESC LIMIT ?`
+	return queryHistory(cmd, u.ID, before, before, u.ID, u.workspaceID, limit)
+}
+
+// RevertTodo puts the tracked fields of one of the user's todos back to how
+// they were right after history entry versionID, by undoing every later
+// change. The revert is itself recorded, so it can be reverted too.
+func (u *User) RevertTodo(todoID, versionID int) (todo Todo, err error) {
+	current, err := u.GetTodo(todoID)
+	if err != nil {
+		return todo, err
+	}
+	var count int
+	cmd := `SELECT COUNT(*) FROM todo_history WHERE id = ? AND todo_id = ?`
+	if err = Db.QueryRow(cmd, versionID, todoID).Scan(&count); err != nil {
+		return todo, err
+	}
+	if count == 0 {
+		return todo, sql.ErrNoRows
+	}
+
+	cmd = `SELECT ` + historyColumns + ` FROM ` + historyTables + `
+	WHERE todo_history.todo_id = ? AND todo_history.id > ? ORDER BY todo_history.id DESC`
+	later, err := queryHistory(cmd, todoID, versionID)
+	if err != nil {
+		return todo, err
+	}
+	state := make(map[string]interface{})
+	for _, f := range historyFields(&current) {
+		state[f.Field] = f.New
+	}
+	for _, e := range later {
+		for _, c := range e.Changes {
+			state[c.Field] = c.Old
+		}
+	}
+	return u.patchTodo(todoID, patchFromHistory(state), HistoryReverted)
+}
+
+// patchFromHistory turns tracked field values, as decoded from JSON, into a
+// patch setting all of them.
+func patchFromHistory(state map[string]interface{}) TodoPatch {
+	str := func(field string) *string {
+		s, _ := state[field].(string)
+		return &s
+	}
+	id := func(field string) *int {
+		n, _ := state[field].(float64) // nil means unset, which patches as 0
+		i := int(n)
+		if v, ok := state[field].(int); ok {
+			i = v
+		}
+		return &i
+	}
+	priority := Priority(*str("priority"))
+	status := Status(*str("status"))
+	return TodoPatch{
+		Content:     str("content"),
+		Description: str("description"),
+		Priority:    &priority,
+		Status:      &status,
+		DueDate:     str("due_date"),
+		DueTime:     str("due_time"),
+		ProjectID:   id("project_id"),
+		ParentID:    id("parent_id"),
+		Recurrence:  str("recurrence"),
+	}
+}
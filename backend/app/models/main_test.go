@@ -0,0 +1,135 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"todo_app/config"
+)
+
+// testConfig points the database and attachments at the scratch directory
+// and keeps password hashing cheap.
+const testConfig = `[db]
+driver = sqlite3
+name = %[1]s
+
+[attachments]
+dir = %[2]s
+
+[password]
+argon2_memory = 8192
+argon2_threads = 1
+`
+
+// TestMain runs the package's tests against a fresh database in a
+// temporary directory, which is removed afterwards.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "todo_app_test")
+	if err != nil {
+		fmt.Println("Creating test directory failed:", err)
+		os.Exit(1)
+	}
+	os.Exit(runTests(m, dir))
+}
+
+func runTests(m *testing.M, dir string) int {
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.ini")
+	settings := fmt.Sprintf(testConfig, filepath.Join(dir, "test.sql"), filepath.Join(dir, "attachments"))
+	if err := os.WriteFile(path, []byte(settings), 0o600); err != nil {
+		fmt.Println("Writing test config failed:", err)
+		return 1
+	}
+	config.Load(path)
+	ConfigurePasswordHasher(config.Config.Argon2Time, config.Config.Argon2Memory, config.Config.Argon2Threads)
+	if err := Open(); err != nil {
+		return 1
+	}
+	defer Db.Close()
+	if _, err := MigrateUp(false); err != nil {
+		fmt.Println("Migration failed:", err)
+		return 1
+	}
+	return m.Run()
+}
+
+var testUsers atomic.Int64
+
+// newTestEmail returns an address no test has used yet.
+func newTestEmail() string {
+	return fmt.Sprintf("user%d@example.com", testUsers.Add(1))
+}
+
+// newTestUser signs up a user with a unique email address.
+func newTestUser(t *testing.T) User {
+	t.Helper()
+	return newTestUserWithEmail(t, newTestEmail())
+}
+
+// newTestUserWithEmail signs up a user with the address, which may already
+// belong to another account.
+func newTestUserWithEmail(t *testing.T, email string) User {
+	t.Helper()
+	u := User{Name: "user", Email: email, Password: "password1"}
+	if err := u.CreateUser(); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	var id int
+	if err := Db.QueryRow(`SELECT id FROM users WHERE email = ? ORDER BY id DESC LIMIT 1`, email).Scan(&id); err != nil {
+		t.Fatalf("reading user id: %v", err)
+	}
+	user, err := GetUser(id)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	return user
+}
+
+func newTestTodo(t *testing.T, u User, content string) Todo {
+	t.Helper()
+	todo, err := u.CreateTodo(Todo{Content: content})
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	return todo
+}
+
+// newTestWorkspace creates a workspace owned by owner and adds each member
+// with the role given for them.
+func newTestWorkspace(t *testing.T, owner User, members map[*User]Role) Workspace {
+	t.Helper()
+	ws, err := owner.CreateWorkspace("Team")
+	if err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	for member, role := range members {
+		joinTestWorkspace(t, owner, *member, ws.ID, role)
+	}
+	return ws
+}
+
+// joinTestWorkspace invites member into the workspace with the role and
+// accepts.
+func joinTestWorkspace(t *testing.T, owner, member User, workspaceID int, role Role) {
+	t.Helper()
+	invitation, err := owner.InviteMember(workspaceID, member.Email, role)
+	if err != nil {
+		t.Fatalf("InviteMember: %v", err)
+	}
+	if _, err = member.RespondToInvitation(invitation.ID, true); err != nil {
+		t.Fatalf("RespondToInvitation: %v", err)
+	}
+}
+
+// workspaceScope is u acting in the workspace.
+func workspaceScope(t *testing.T, u User, workspaceID int) User {
+	t.Helper()
+	scope, err := u.WorkspaceScope(workspaceID, RoleViewer)
+	if err != nil {
+		t.Fatalf("WorkspaceScope: %v", err)
+	}
+	return scope
+}
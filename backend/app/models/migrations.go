@@ -0,0 +1,886 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Migration is a single numbered schema change. Up and Down run inside a
+// transaction together with the bookkeeping row in schema_migrations.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrations must stay ordered by Version. Never edit a migration that has
+// been released; add a new one instead.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_users_todos_sessions",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS users(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					uuid STRING NOT NULL UNIQUE,
+					name STRING,
+					email STRING,
+					password STRING,
+					created_at DATETIME)`,
+				`CREATE TABLE IF NOT EXISTS todos(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					content TEXT,
+					user_id INTEGER,
+					created_at DATETIME)`,
+				`CREATE TABLE IF NOT EXISTS sessions(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					uuid STRING NOT NULL UNIQUE,
+					email STRING,
+					user_id INTEGER,
+					created_at DATETIME)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`DROP TABLE IF EXISTS sessions`,
+				`DROP TABLE IF EXISTS todos`,
+				`DROP TABLE IF EXISTS users`,
+			)
+		},
+	},
+	{
+		// Databases created before the migration engine may already have
+		// these columns, so they are only added when missing.
+		Version: 2,
+		Name:    "add_todo_priority_status_due_date",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "todos", "priority", `TEXT DEFAULT 'medium'`); err != nil {
+				return err
+			}
+			if err := addColumn(tx, "todos", "status", `TEXT DEFAULT 'todo'`); err != nil {
+				return err
+			}
+			// SQLite refuses ADD COLUMN with a non-constant default on a
+			// populated table, so due_date is backfilled below instead.
+			if err := addColumn(tx, "todos", "due_date", `DATE`); err != nil {
+				return err
+			}
+			return execAll(tx,
+				`UPDATE todos SET priority = 'medium' WHERE priority IS NULL`,
+				`UPDATE todos SET status = 'todo' WHERE status IS NULL`,
+				`UPDATE todos SET due_date = date('now') WHERE due_date IS NULL`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return dropColumns(tx, "todos", "due_date", "status", "priority")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_session_last_seen_at",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "sessions", "last_seen_at", `DATETIME`); err != nil {
+				return err
+			}
+			return execAll(tx, `UPDATE sessions SET last_seen_at = created_at WHERE last_seen_at IS NULL`)
+		},
+		Down: func(tx *sql.Tx) error {
+			return dropColumns(tx, "sessions", "last_seen_at")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_session_user_agent_ip",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "sessions", "user_agent", `TEXT`); err != nil {
+				return err
+			}
+			if err := addColumn(tx, "sessions", "ip", `TEXT`); err != nil {
+				return err
+			}
+			return execAll(tx, `CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`)
+		},
+		Down: func(tx *sql.Tx) error {
+			if err := execAll(tx, `DROP INDEX IF EXISTS idx_sessions_user_id`); err != nil {
+				return err
+			}
+			return dropColumns(tx, "sessions", "ip", "user_agent")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_todo_listing_indexes",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE INDEX IF NOT EXISTS idx_todos_user_created ON todos(user_id, created_at)`,
+				`CREATE INDEX IF NOT EXISTS idx_todos_user_status ON todos(user_id, status)`,
+				`CREATE INDEX IF NOT EXISTS idx_todos_user_due_date ON todos(user_id, due_date)`,
+				`CREATE INDEX IF NOT EXISTS idx_todos_user_priority ON todos(user_id, priority)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`DROP INDEX IF EXISTS idx_todos_user_priority`,
+				`DROP INDEX IF EXISTS idx_todos_user_due_date`,
+				`DROP INDEX IF EXISTS idx_todos_user_status`,
+				`DROP INDEX IF EXISTS idx_todos_user_created`,
+			)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_projects",
+		Up: func(tx *sql.Tx) error {
+			err := execAll(tx,
+				`CREATE TABLE IF NOT EXISTS projects(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id INTEGER NOT NULL,
+					name TEXT NOT NULL,
+					color TEXT,
+					created_at DATETIME)`,
+				`CREATE INDEX IF NOT EXISTS idx_projects_user ON projects(user_id)`,
+			)
+			if err != nil {
+				return err
+			}
+			if err := addColumn(tx, "todos", "project_id", `INTEGER`); err != nil {
+				return err
+			}
+			return execAll(tx, `CREATE INDEX IF NOT EXISTS idx_todos_user_project ON todos(user_id, project_id)`)
+		},
+		Down: func(tx *sql.Tx) error {
+			if err := execAll(tx, `DROP INDEX IF EXISTS idx_todos_user_project`); err != nil {
+				return err
+			}
+			if err := dropColumns(tx, "todos", "project_id"); err != nil {
+				return err
+			}
+			return execAll(tx, `DROP TABLE IF EXISTS projects`)
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_todo_description",
+		Up: func(tx *sql.Tx) error {
+			return addColumn(tx, "todos", "description", `TEXT NOT NULL DEFAULT ''`)
+		},
+		Down: func(tx *sql.Tx) error {
+			return dropColumns(tx, "todos", "description")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_tags",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS tags(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id INTEGER NOT NULL,
+					name TEXT NOT NULL,
+					color TEXT,
+					created_at DATETIME)`,
+				`CREATE INDEX IF NOT EXISTS idx_tags_user ON tags(user_id)`,
+				`CREATE TABLE IF NOT EXISTS todo_tags(
+					todo_id INTEGER NOT NULL,
+					tag_id INTEGER NOT NULL,
+					PRIMARY KEY (todo_id, tag_id))`,
+				`CREATE INDEX IF NOT EXISTS idx_todo_tags_tag ON todo_tags(tag_id)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`DROP TABLE IF EXISTS todo_tags`,
+				`DROP TABLE IF EXISTS tags`,
+			)
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_todo_parent",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "todos", "parent_id", `INTEGER`); err != nil {
+				return err
+			}
+			if err := addColumn(tx, "todos", "position", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+				return err
+			}
+			return execAll(tx, `CREATE INDEX IF NOT EXISTS idx_todos_parent ON todos(parent_id, position)`)
+		},
+		Down: func(tx *sql.Tx) error {
+			if err := execAll(tx, `DROP INDEX IF EXISTS idx_todos_parent`); err != nil {
+				return err
+			}
+			return dropColumns(tx, "todos", "parent_id", "position")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add_todo_recurrence",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "todos", "recurrence", `TEXT NOT NULL DEFAULT ''`); err != nil {
+				return err
+			}
+			if err := addColumn(tx, "todos", "series_id", `INTEGER`); err != nil {
+				return err
+			}
+			if err := addColumn(tx, "todos", "occurrence", `INTEGER NOT NULL DEFAULT 1`); err != nil {
+				return err
+			}
+			return execAll(tx, `CREATE INDEX IF NOT EXISTS idx_todos_series ON todos(series_id, occurrence)`)
+		},
+		Down: func(tx *sql.Tx) error {
+			if err := execAll(tx, `DROP INDEX IF EXISTS idx_todos_series`); err != nil {
+				return err
+			}
+			return dropColumns(tx, "todos", "recurrence", "series_id", "occurrence")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "create_reminders",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS reminders(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id INTEGER NOT NULL,
+					todo_id INTEGER NOT NULL,
+					remind_at DATETIME,
+					offset_minutes INTEGER,
+					channel TEXT NOT NULL,
+					status TEXT NOT NULL DEFAULT 'pending',
+					attempts INTEGER NOT NULL DEFAULT 0,
+					last_error TEXT NOT NULL DEFAULT '',
+					sent_at DATETIME,
+					created_at DATETIME)`,
+				`CREATE INDEX IF NOT EXISTS idx_reminders_todo ON reminders(todo_id)`,
+				`CREATE INDEX IF NOT EXISTS idx_reminders_status ON reminders(status)`,
+				`CREATE TABLE IF NOT EXISTS notifications(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id INTEGER NOT NULL,
+					todo_id INTEGER,
+					reminder_id INTEGER,
+					title TEXT NOT NULL,
+					body TEXT NOT NULL DEFAULT '',
+					read_at DATETIME,
+					created_at DATETIME)`,
+				`CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, id)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`DROP TABLE IF EXISTS notifications`,
+				`DROP TABLE IF EXISTS reminders`,
+			)
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_time_zones",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "users", "time_zone", `TEXT NOT NULL DEFAULT 'UTC'`); err != nil {
+				return err
+			}
+			if err := addColumn(tx, "todos", "due_at", `DATETIME`); err != nil {
+				return err
+			}
+			// Earlier versions stored timestamps in the server's zone.
+			for _, c := range utcTimestampColumns {
+				cmd := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = strftime('%%Y-%%m-%%d %%H:%%M:%%f+00:00', %[2]s) WHERE %[2]s IS NOT NULL`, c[0], c[1])
+				if _, err := tx.Exec(cmd); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *sql.Tx) error {
+			if err := dropColumns(tx, "todos", "due_at"); err != nil {
+				return err
+			}
+			return dropColumns(tx, "users", "time_zone")
+		},
+	},
+	{
+		Version: 13,
+		Name:    "add_todo_deleted_at",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "todos", "deleted_at", `DATETIME`); err != nil {
+				return err
+			}
+			return execAll(tx, `CREATE INDEX IF NOT EXISTS idx_todos_deleted ON todos(deleted_at)`)
+		},
+		Down: func(tx *sql.Tx) error {
+			// Trashed todos would reappear as live ones, so they go for good.
+			if err := execAll(tx,
+				`DELETE FROM todo_tags WHERE todo_id IN (SELECT id FROM todos WHERE deleted_at IS NOT NULL)`,
+				`DELETE FROM reminders WHERE todo_id IN (SELECT id FROM todos WHERE deleted_at IS NOT NULL)`,
+				`DELETE FROM todos WHERE deleted_at IS NOT NULL`,
+				`DROP INDEX IF EXISTS idx_todos_deleted`,
+			); err != nil {
+				return err
+			}
+			return dropColumns(tx, "todos", "deleted_at")
+		},
+	},
+	{
+		Version: 14,
+		Name:    "create_todo_history",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS todo_history(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					todo_id INTEGER NOT NULL,
+					user_id INTEGER NOT NULL,
+					actor_id INTEGER NOT NULL,
+					action TEXT NOT NULL,
+					changes TEXT NOT NULL DEFAULT '[]',
+					created_at DATETIME)`,
+				`CREATE INDEX IF NOT EXISTS idx_todo_history_todo ON todo_history(todo_id, id)`,
+				`CREATE INDEX IF NOT EXISTS idx_todo_history_user ON todo_history(user_id, id)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TABLE IF EXISTS todo_history`)
+		},
+	},
+	{
+		Version: 15,
+		Name:    "add_todo_rank",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "todos", "rank", `REAL NOT NULL DEFAULT 0`); err != nil {
+				return err
+			}
+			// Existing todos keep their creation order.
+			return execAll(tx,
+				`UPDATE todos SET rank = id * 1024 WHERE rank = 0`,
+				`CREATE INDEX IF NOT EXISTS idx_todos_user_rank ON todos(user_id, status, project_id, rank)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			if err := execAll(tx, `DROP INDEX IF EXISTS idx_todos_user_rank`); err != nil {
+				return err
+			}
+			return dropColumns(tx, "todos", "rank")
+		},
+	},
+	{
+		Version: 16,
+		Name:    "create_workflows",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS workflow_columns(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					project_id INTEGER NOT NULL,
+					key TEXT NOT NULL,
+					name TEXT NOT NULL,
+					position INTEGER NOT NULL DEFAULT 0,
+					done BOOLEAN NOT NULL DEFAULT 0,
+					wip_limit INTEGER NOT NULL DEFAULT 0,
+					UNIQUE(project_id, key))`,
+				`CREATE TABLE IF NOT EXISTS workflow_transitions(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					project_id INTEGER NOT NULL,
+					from_status TEXT NOT NULL,
+					to_status TEXT NOT NULL,
+					UNIQUE(project_id, from_status, to_status))`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			// Todos in custom columns fall back to the default workflow.
+			return execAll(tx,
+				`UPDATE todos SET status = CASE WHEN EXISTS (SELECT 1 FROM workflow_columns wc
+					WHERE wc.project_id = todos.project_id AND wc.key = todos.status AND wc.done) THEN 'completed' ELSE 'todo' END
+				WHERE COALESCE(status, 'todo') NOT IN ('todo', 'in_progress', 'completed')`,
+				`DROP TABLE IF EXISTS workflow_transitions`,
+				`DROP TABLE IF EXISTS workflow_columns`,
+			)
+		},
+	},
+	{
+		Version: 17,
+		Name:    "add_todo_status_tracking",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "todos", "updated_at", `DATETIME`); err != nil {
+				return err
+			}
+			if err := addColumn(tx, "todos", "completed_at", `DATETIME`); err != nil {
+				return err
+			}
+			// Existing todos take both times from their history, as far as
+			// it goes back, and the log starts with the status changes in it.
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS todo_status_changes(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					todo_id INTEGER NOT NULL,
+					user_id INTEGER NOT NULL,
+					actor_id INTEGER NOT NULL,
+					from_status TEXT,
+					to_status TEXT NOT NULL,
+					created_at DATETIME)`,
+				`CREATE INDEX IF NOT EXISTS idx_todo_status_changes_todo ON todo_status_changes(todo_id, id)`,
+				`INSERT INTO todo_status_changes (todo_id, user_id, actor_id, from_status, to_status, created_at)
+				SELECT h.todo_id, h.user_id, h.actor_id, json_extract(c.value, '$.old'), json_extract(c.value, '$.new'), h.created_at
+				FROM todo_history h, json_each(h.changes) c
+				WHERE json_extract(c.value, '$.field') = 'status'
+				ORDER BY h.id`,
+				`UPDATE todos SET updated_at = COALESCE(
+					(SELECT h.created_at FROM todo_history h WHERE h.todo_id = todos.id ORDER BY h.id DESC LIMIT 1),
+					created_at)`,
+				`UPDATE todos SET completed_at = COALESCE(
+					(SELECT s.created_at FROM todo_status_changes s WHERE s.todo_id = todos.id ORDER BY s.id DESC LIMIT 1),
+					updated_at)
+				WHERE CASE WHEN EXISTS (SELECT 1 FROM workflow_columns wc WHERE wc.project_id = todos.project_id)
+					THEN EXISTS (SELECT 1 FROM workflow_columns wc WHERE wc.project_id = todos.project_id AND wc.key = todos.status AND wc.done)
+					ELSE COALESCE(todos.status, 'todo') = 'completed' END`,
+				`CREATE INDEX IF NOT EXISTS idx_todos_user_completed ON todos(user_id, completed_at)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			if err := execAll(tx,
+				`DROP INDEX IF EXISTS idx_todos_user_completed`,
+				`DROP TABLE IF EXISTS todo_status_changes`,
+			); err != nil {
+				return err
+			}
+			return dropColumns(tx, "todos", "updated_at", "completed_at")
+		},
+	},
+	{
+		Version: 18,
+		Name:    "create_attachments",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS attachments(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					todo_id INTEGER NOT NULL,
+					user_id INTEGER NOT NULL,
+					filename TEXT NOT NULL,
+					content_type TEXT NOT NULL,
+					size INTEGER NOT NULL,
+					blob_key TEXT NOT NULL,
+					created_at DATETIME)`,
+				`CREATE INDEX IF NOT EXISTS idx_attachments_todo ON attachments(todo_id)`,
+				`CREATE INDEX IF NOT EXISTS idx_attachments_user ON attachments(user_id)`,
+				`CREATE INDEX IF NOT EXISTS idx_attachments_blob ON attachments(blob_key)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			// The stored files are left on disk.
+			return execAll(tx, `DROP TABLE IF EXISTS attachments`)
+		},
+	},
+	{
+		Version: 19,
+		Name:    "create_comments",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS comments(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					todo_id INTEGER NOT NULL,
+					user_id INTEGER NOT NULL,
+					author_id INTEGER NOT NULL,
+					body TEXT NOT NULL,
+					created_at DATETIME,
+					edited_at DATETIME)`,
+				`CREATE INDEX IF NOT EXISTS idx_comments_todo ON comments(todo_id, id)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TABLE IF EXISTS comments`)
+		},
+	},
+	{
+		Version: 20,
+		Name:    "create_workspaces",
+		Up: func(tx *sql.Tx) error {
+			err := execAll(tx,
+				`CREATE TABLE IF NOT EXISTS workspaces(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					name TEXT NOT NULL,
+					owner_id INTEGER NOT NULL,
+					created_at DATETIME)`,
+				`CREATE TABLE IF NOT EXISTS workspace_members(
+					workspace_id INTEGER NOT NULL,
+					user_id INTEGER NOT NULL,
+					role TEXT NOT NULL,
+					created_at DATETIME,
+					PRIMARY KEY(workspace_id, user_id))`,
+				`CREATE INDEX IF NOT EXISTS idx_workspace_members_user ON workspace_members(user_id)`,
+				`CREATE TABLE IF NOT EXISTS workspace_invitations(
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					workspace_id INTEGER NOT NULL,
+					user_id INTEGER NOT NULL,
+					inviter_id INTEGER NOT NULL,
+					role TEXT NOT NULL,
+					status TEXT NOT NULL DEFAULT 'pending',
+					created_at DATETIME,
+					responded_at DATETIME)`,
+				`CREATE INDEX IF NOT EXISTS idx_workspace_invitations_user ON workspace_invitations(user_id, status)`,
+				`CREATE INDEX IF NOT EXISTS idx_workspace_invitations_workspace ON workspace_invitations(workspace_id, status)`,
+			)
+			if err != nil {
+				return err
+			}
+			// Rows without a workspace stay in their owner's personal space.
+			for _, table := range []string{"todos", "projects", "tags"} {
+				if err = addColumn(tx, table, "workspace_id", `INTEGER`); err != nil {
+					return err
+				}
+				if _, err = tx.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%[1]s_workspace ON %[1]s(workspace_id)`, table)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *sql.Tx) error {
+			// Workspace content goes back to the personal space of the
+			// workspace owner, under whose user_id it is already stored.
+			for _, table := range []string{"todos", "projects", "tags"} {
+				if _, err := tx.Exec(fmt.Sprintf(`DROP INDEX IF EXISTS idx_%s_workspace`, table)); err != nil {
+					return err
+				}
+				if err := dropColumns(tx, table, "workspace_id"); err != nil {
+					return err
+				}
+			}
+			return execAll(tx,
+				`DROP TABLE IF EXISTS workspace_invitations`,
+				`DROP TABLE IF EXISTS workspace_members`,
+				`DROP TABLE IF EXISTS workspaces`,
+			)
+		},
+	},
+	{
+		Version: 21,
+		Name:    "add_invitation_emails",
+		Up: func(tx *sql.Tx) error {
+			// Invitations keep the address they were sent to, so ones to
+			// addresses without an account (user_id 0) can be listed too.
+			if err := addColumn(tx, "workspace_invitations", "email", `TEXT NOT NULL DEFAULT ''`); err != nil {
+				return err
+			}
+			return execAll(tx,
+				`UPDATE workspace_invitations SET email = COALESCE((SELECT email FROM users WHERE users.id = workspace_invitations.user_id), '')`,
+				`CREATE INDEX IF NOT EXISTS idx_workspace_invitations_email ON workspace_invitations(email, status)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			// Invitations to addresses without an account are dropped.
+			err := execAll(tx,
+				`DELETE FROM workspace_invitations WHERE user_id = 0`,
+				`DROP INDEX IF EXISTS idx_workspace_invitations_email`,
+			)
+			if err != nil {
+				return err
+			}
+			return dropColumns(tx, "workspace_invitations", "email")
+		},
+	},
+	{
+		Version: 22,
+		Name:    "normalize_todo_listing_columns",
+		Up: func(tx *sql.Tx) error {
+			// Listings filter and sort on the bare columns so the indexes
+			// of migration 5 apply: status and priority must not be NULL,
+			// and timestamps must all be in the format the driver writes
+			// so that they compare correctly as text.
+			err := execAll(tx,
+				`UPDATE todos SET priority = 'medium' WHERE priority IS NULL`,
+				`UPDATE todos SET status = 'todo' WHERE status IS NULL`,
+			)
+			if err != nil {
+				return err
+			}
+			for _, column := range []string{"created_at", "updated_at", "completed_at", "deleted_at"} {
+				if err = normalizeTimestamps(tx, "todos", column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *sql.Tx) error {
+			// The normalized values are still valid timestamps.
+			return nil
+		},
+	},
+	{
+		Version: 23,
+		Name:    "add_reminder_fire_at",
+		Up: func(tx *sql.Tx) error {
+			// The scheduler selects due reminders by their stored fire
+			// time instead of working it out for every pending one.
+			if err := addColumn(tx, "reminders", "fire_at", `DATETIME`); err != nil {
+				return err
+			}
+			if err := refreshFireAt(tx, `1`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_reminders_due ON reminders(status, fire_at)`)
+			return err
+		},
+		Down: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`DROP INDEX IF EXISTS idx_reminders_due`); err != nil {
+				return err
+			}
+			return dropColumns(tx, "reminders", "fire_at")
+		},
+	},
+}
+
+// utcTimestampColumns lists the table and column of every stored timestamp.
+var utcTimestampColumns = [][2]string{
+	{"users", "created_at"},
+	{"sessions", "created_at"},
+	{"sessions", "last_seen_at"},
+	{"todos", "created_at"},
+	{"projects", "created_at"},
+	{"tags", "created_at"},
+	{"reminders", "remind_at"},
+	{"reminders", "sent_at"},
+	{"reminders", "created_at"},
+	{"notifications", "read_at"},
+	{"notifications", "created_at"},
+}
+
+// MigrateUp applies every pending migration in order. With dryRun the
+// migrations are executed and then rolled back, so SQL errors still surface.
+func MigrateUp(dryRun bool) (applied []Migration, err error) {
+	if err = ensureMigrationTable(); err != nil {
+		return nil, err
+	}
+	done, err := appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if _, ok := done[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	err = runMigrations(pending, dryRun, func(tx *sql.Tx, m Migration) error {
+		if err := m.Up(tx); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+			m.Version, m.Name, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// MigrateDown reverts the most recently applied migrations, newest first.
+func MigrateDown(steps int, dryRun bool) (reverted []Migration, err error) {
+	if err = ensureMigrationTable(); err != nil {
+		return nil, err
+	}
+	done, err := appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []Migration
+	for i := len(migrations) - 1; i >= 0 && len(targets) < steps; i-- {
+		if _, ok := done[migrations[i].Version]; ok {
+			targets = append(targets, migrations[i])
+		}
+	}
+	err = runMigrations(targets, dryRun, func(tx *sql.Tx, m Migration) error {
+		if m.Down == nil {
+			return fmt.Errorf("migration %d has no down step", m.Version)
+		}
+		if err := m.Down(tx); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.Version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+func GetMigrationStatus() (statuses []MigrationStatus, err error) {
+	if err = ensureMigrationTable(); err != nil {
+		return nil, err
+	}
+	done, err := appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range migrations {
+		appliedAt, ok := done[m.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   m.Version,
+			Name:      m.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+// runMigrations gives every migration its own transaction. A dry run shares
+// one transaction across all steps so later migrations see earlier ones, and
+// rolls it back at the end.
+func runMigrations(list []Migration, dryRun bool, step func(tx *sql.Tx, m Migration) error) error {
+	if len(list) == 0 {
+		return nil
+	}
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	for i, m := range list {
+		if err := step(tx, m); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if dryRun {
+			log.Printf("Migration %d (%s) ok (dry run)", m.Version, m.Name)
+			continue
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		log.Printf("Migration %d (%s) done", m.Version, m.Name)
+		if i < len(list)-1 {
+			if tx, err = Db.Begin(); err != nil {
+				return err
+			}
+		}
+	}
+	if dryRun {
+		return tx.Rollback()
+	}
+	return nil
+}
+
+func ensureMigrationTable() error {
+	cmd := `CREATE TABLE IF NOT EXISTS schema_migrations(
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME)`
+	_, err := Db.Exec(cmd)
+	return err
+}
+
+func appliedVersions() (map[int]time.Time, error) {
+	rows, err := Db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = appliedAt
+	}
+	return done, rows.Err()
+}
+
+// normalizeTimestamps rewrites a timestamp column in the format the driver
+// stores time.Time values in. Migration 12 and older rows used others.
+func normalizeTimestamps(tx *sql.Tx, table, column string) error {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT id, %[2]s FROM %[1]s WHERE %[2]s IS NOT NULL`, table, column))
+	if err != nil {
+		return err
+	}
+	stamps := make(map[int]time.Time)
+	for rows.Next() {
+		var id int
+		var t time.Time
+		if err = rows.Scan(&id, &t); err != nil {
+			rows.Close()
+			return fmt.Errorf("%s.%s of row %d: %w", table, column, id, err)
+		}
+		stamps[id] = t
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	cmd := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE id = ?`, table, column)
+	for id, t := range stamps {
+		if _, err = tx.Exec(cmd, t.UTC(), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func execAll(tx *sql.Tx, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, dtype string
+		var dfltValue sql.NullString
+		if err := rows.Scan(&cid, &name, &dtype, &notnull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// addColumn is a no-op when the column is already there.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
+func dropColumns(tx *sql.Tx, table string, columns ...string) error {
+	for _, column := range columns {
+		exists, err := columnExists(tx, table, column)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s DROP COLUMN %s`, table, column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Notification is an entry in a user's in-app inbox.
+type Notification struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	TodoID     *int       `json:"todo_id"`
+	ReminderID *int       `json:"reminder_id"`
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	ReadAt     *time.Time `json:"read_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+const notificationColumns = `id, user_id, todo_id, reminder_id, title, body, read_at, created_at`
+
+func notificationFields(n *Notification) []interface{} {
+	return []interface{}{&n.ID, &n.UserID, &n.TodoID, &n.ReminderID, &n.Title, &n.Body, &n.ReadAt, &n.CreatedAt}
+}
+
+// GetNotifications returns the user's notifications, newest first.
+func (u *User) GetNotifications(unreadOnly bool) (notifications []Notification, err error) {
+	cmd := `SELECT ` + notificationColumns + ` FROM notifications WHERE user_id = ?`
+	if unreadOnly {
+		cmd += ` AND read_at IS NULL`
+	}
+	cmd += ` ORDER BY id DESC`
+	rows, err := Db.Query(cmd, u.ID)
+	if err != nil {
+		log.Println("GetNotifications error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n Notification
+		if err = rows.Scan(notificationFields(&n)...); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkNotificationRead marks one of the user's notifications as read.
+// Marking it again keeps the original read time.
+func (u *User) MarkNotificationRead(id int) (n Notification, err error) {
+	cmd := `UPDATE notifications SET read_at = COALESCE(read_at, ?) WHERE id = ? AND user_id = ?`
+	result, err := Db.Exec(cmd, time.Now().UTC(), id, u.ID)
+	if err != nil {
+		log.Println("MarkNotificationRead error:", err)
+		return n, err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return n, sql.ErrNoRows
+	}
+	cmd = `SELECT ` + notificationColumns + ` FROM notifications WHERE id = ?`
+	err = Db.QueryRow(cmd, id).Scan(notificationFields(&n)...)
+	return n, err
+}
+
+func (u *User) MarkAllNotificationsRead() (marked int64, err error) {
+	cmd := `UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL`
+	result, err := Db.Exec(cmd, time.Now().UTC(), u.ID)
+	if err != nil {
+		log.Println("MarkAllNotificationsRead error:", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
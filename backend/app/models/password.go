@@ -0,0 +1,197 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"todo_app/config"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher produces self-describing encoded hashes, so a stored value
+// always says which algorithm and parameters were used to create it.
+type PasswordHasher interface {
+	// Matches reports whether encoded was produced by this hasher.
+	Matches(encoded string) bool
+	Hash(password string) (encoded string, err error)
+	Verify(password, encoded string) (ok bool, err error)
+	// NeedsRehash reports whether encoded uses weaker settings than the
+	// hasher is currently configured with.
+	NeedsRehash(encoded string) bool
+}
+
+// DefaultPasswordHasher is used for every new hash. The other entries in
+// passwordHashers are only kept around to verify old hashes. Its cost is
+// set from the config by ConfigurePasswordHasher.
+var DefaultPasswordHasher PasswordHasher = newArgon2idHasher(1, 64*1024, 4)
+
+var passwordHashers = []PasswordHasher{
+	DefaultPasswordHasher,
+	legacySHA1Hasher{},
+}
+
+func newArgon2idHasher(time, memory, threads int) *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    uint32(time),
+		Memory:  uint32(memory),
+		Threads: uint8(threads),
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+// ConfigurePasswordHasher sets the argon2id cost of new hashes. Existing
+// hashes with a lower cost are upgraded at their next login.
+func ConfigurePasswordHasher(time, memory, threads int) {
+	DefaultPasswordHasher = newArgon2idHasher(time, memory, threads)
+	passwordHashers[0] = DefaultPasswordHasher
+}
+
+func HashPassword(password string) (string, error) {
+	return DefaultPasswordHasher.Hash(password)
+}
+
+// VerifyPassword checks password against a stored hash of any supported
+// format. needsRehash is true when the password matched but the hash should
+// be replaced with one from DefaultPasswordHasher.
+func VerifyPassword(password, encoded string) (ok bool, needsRehash bool, err error) {
+	for _, h := range passwordHashers {
+		if !h.Matches(encoded) {
+			continue
+		}
+		ok, err = h.Verify(password, encoded)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		if h != DefaultPasswordHasher {
+			return true, true, nil
+		}
+		return true, h.NeedsRehash(encoded), nil
+	}
+	return false, false, ErrUnknownPasswordHash
+}
+
+// Argon2idHasher encodes hashes in the PHC string format:
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+type argon2idParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (h *Argon2idHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.memory < h.Memory || p.time < h.Time || p.threads != h.Threads ||
+		uint32(len(p.salt)) < h.SaltLen || uint32(len(p.key)) < h.KeyLen
+}
+
+func decodeArgon2id(encoded string) (p argon2idParams, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, ErrUnknownPasswordHash
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, err
+	}
+	if version != argon2.Version {
+		return p, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, err
+	}
+	// The parameters come from the database; a zero would make argon2
+	// panic and a huge one would tie up the server on every login.
+	if p.time < 1 || p.time > config.MaxArgon2Time {
+		return p, fmt.Errorf("argon2 time must be between 1 and %d, got %d", config.MaxArgon2Time, p.time)
+	}
+	if p.threads < 1 {
+		return p, fmt.Errorf("argon2 threads must be between 1 and %d, got %d", config.MaxArgon2Threads, p.threads)
+	}
+	if p.memory < 8*uint32(p.threads) || p.memory > config.MaxArgon2Memory {
+		return p, fmt.Errorf("argon2 memory must be between %d and %d KiB, got %d", 8*uint32(p.threads), config.MaxArgon2Memory, p.memory)
+	}
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, err
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, err
+	}
+	// An empty key would match every password.
+	if len(p.salt) == 0 || len(p.key) == 0 {
+		return p, errors.New("argon2 salt and key must not be empty")
+	}
+	return p, nil
+}
+
+// legacySHA1Hasher verifies the unsalted SHA-1 hex digests stored before
+// argon2id was introduced. Users are rehashed on their next login.
+type legacySHA1Hasher struct{}
+
+func (legacySHA1Hasher) Matches(encoded string) bool {
+	if len(encoded) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(encoded)
+	return err == nil
+}
+
+func (legacySHA1Hasher) Hash(password string) (string, error) {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(password))), nil
+}
+
+func (h legacySHA1Hasher) Verify(password, encoded string) (bool, error) {
+	digest, _ := h.Hash(password)
+	return subtle.ConstantTimeCompare([]byte(digest), []byte(strings.ToLower(encoded))) == 1, nil
+}
+
+func (legacySHA1Hasher) NeedsRehash(encoded string) bool {
+	return true
+}
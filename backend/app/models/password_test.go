@@ -0,0 +1,42 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArgon2idRejectsOutOfRangeParameters(t *testing.T) {
+	h := newArgon2idHasher(1, 8*1024, 1)
+	encoded, err := h.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if ok, err := h.Verify("secret", encoded); !ok || err != nil {
+		t.Fatalf("Verify = %v, %v, want a match", ok, err)
+	}
+
+	parts := strings.Split(encoded, "$")
+	tests := []struct {
+		name, params, salt, key string
+	}{
+		{"no threads", "m=8192,t=1,p=0", parts[4], parts[5]},
+		{"too many threads", "m=8192,t=1,p=256", parts[4], parts[5]},
+		{"no passes", "m=8192,t=0,p=1", parts[4], parts[5]},
+		{"too many passes", "m=8192,t=4294967295,p=1", parts[4], parts[5]},
+		{"too little memory", "m=7,t=1,p=1", parts[4], parts[5]},
+		{"too much memory", "m=4294967295,t=1,p=1", parts[4], parts[5]},
+		{"empty key", parts[3], parts[4], ""},
+		{"empty salt", parts[3], "", parts[5]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := strings.Join([]string{"", "argon2id", parts[2], tt.params, tt.salt, tt.key}, "$")
+			if ok, err := h.Verify("secret", tampered); ok || err == nil {
+				t.Errorf("Verify(%s) = %v, %v, want an error", tampered, ok, err)
+			}
+			if !h.NeedsRehash(tampered) {
+				t.Errorf("NeedsRehash(%s) = false, want true", tampered)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const MaxProjectNameLength = 100
+
+var projectColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type Project struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	WorkspaceID *int      `json:"workspace_id"` // nil for personal projects
+	Name        string    `json:"name"`
+	Color       string    `json:"color"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (p *Project) Validate() error {
+	v := &ValidationError{}
+	if strings.TrimSpace(p.Name) == "" {
+		v.Add("name", "must not be empty")
+	} else if utf8.RuneCountInString(p.Name) > MaxProjectNameLength {
+		v.Add("name", fmt.Sprintf("must be at most %d characters", MaxProjectNameLength))
+	}
+	if p.Color != "" && !projectColorPattern.MatchString(p.Color) {
+		v.Add("color", "must be a hex colour like #1e90ff")
+	}
+	return v.Err()
+}
+
+const projectColumns = `id, user_id, workspace_id, name, COALESCE(color, ''), created_at`
+
+func projectFields(p *Project) []interface{} {
+	return []interface{}{&p.ID, &p.UserID, &p.WorkspaceID, &p.Name, &p.Color, &p.CreatedAt}
+}
+
+func (u *User) CreateProject(name, color string) (project Project, err error) {
+	project = Project{UserID: u.ID, WorkspaceID: u.workspaceRef(), Name: strings.TrimSpace(name), Color: color}
+	if err = project.Validate(); err != nil {
+		return Project{}, err
+	}
+	if err = u.checkProjectNameFree(project.Name, 0); err != nil {
+		return Project{}, err
+	}
+
+	cmd := `INSERT INTO projects (user_id, workspace_id, name, color, created_at) VALUES (?, ?, ?, ?, ?)`
+	result, err := Db.Exec(cmd, u.ID, project.WorkspaceID, project.Name, project.Color, time.Now().UTC())
+	if err != nil {
+		log.Println("CreateProject error:", err)
+		return Project{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Project{}, err
+	}
+	return u.GetProject(int(id))
+}
+
+// GetProject returns one of the projects in the user's space, or
+// sql.ErrNoRows.
+func (u *User) GetProject(id int) (project Project, err error) {
+	cmd := `SELECT ` + projectColumns + ` FROM projects WHERE id = ? AND user_id = ? AND ` + inWorkspace("projects")
+	err = Db.QueryRow(cmd, id, u.ID, u.workspaceID).Scan(projectFields(&project)...)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("GetProject error:", err)
+	}
+	return project, err
+}
+
+func (u *User) GetProjects() (projects []Project, err error) {
+	cmd := `SELECT ` + projectColumns + ` FROM projects WHERE user_id = ? AND ` + inWorkspace("projects") + ` ORDER BY lower(name), id`
+	rows, err := Db.Query(cmd, u.ID, u.workspaceID)
+	if err != nil {
+		log.Println("GetProjects error:", err)
+		return projects, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var project Project
+		if err = rows.Scan(projectFields(&project)...); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		projects = append(projects, project)
+	}
+	return projects, rows.Err()
+}
+
+// UpdateProject renames or recolours p, which must belong to p.UserID and
+// be in the workspace given by p.WorkspaceID.
+func (p *Project) UpdateProject() (project Project, err error) {
+	p.Name = strings.TrimSpace(p.Name)
+	if err = p.Validate(); err != nil {
+		return project, err
+	}
+	owner := User{ID: p.UserID, workspaceID: idOrZero(p.WorkspaceID)}
+	if err = owner.checkProjectNameFree(p.Name, p.ID); err != nil {
+		return project, err
+	}
+
+	cmd := `UPDATE projects SET name = ?, color = ? WHERE id = ? AND user_id = ?`
+	result, err := Db.Exec(cmd, p.Name, p.Color, p.ID, p.UserID)
+	if err != nil {
+		log.Println("UpdateProject error:", err)
+		return project, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return project, sql.ErrNoRows
+	}
+	return owner.GetProject(p.ID)
+}
+
+// DeleteProject removes p and its workflow. Its todos are kept and simply
+// lose their project; those in custom columns move to the default "todo" or
+// "completed" column depending on whether they were done.
+func (p *Project) DeleteProject() (err error) {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM projects WHERE id = ? AND user_id = ?`, p.ID, p.UserID)
+	if err != nil {
+		log.Println("DeleteProject error:", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	cmd := `UPDATE todos SET status = CASE WHEN ` + todoDoneSQL + ` THEN ? ELSE ? END
+	WHERE project_id = ? AND user_id = ? AND COALESCE(status, 'todo') NOT IN (?, ?, ?)`
+	_, err = tx.Exec(cmd, StatusCompleted, StatusTodo, p.ID, p.UserID, StatusTodo, StatusInProgress, StatusCompleted)
+	if err != nil {
+		log.Println("DeleteProject error:", err)
+		return err
+	}
+	if err = deleteWorkflow(tx, p.ID); err != nil {
+		log.Println("DeleteProject error:", err)
+		return err
+	}
+	if _, err = tx.Exec(`UPDATE todos SET project_id = NULL WHERE project_id = ? AND user_id = ?`, p.ID, p.UserID); err != nil {
+		log.Println("DeleteProject error:", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (u *User) checkProjectNameFree(name string, exceptID int) error {
+	var count int
+	cmd := `SELECT COUNT(*) FROM projects WHERE user_id = ? AND ` + inWorkspace("projects") + ` AND lower(name) = lower(?) AND id != ?`
+	if err := Db.QueryRow(cmd, u.ID, u.workspaceID, name, exceptID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return &ValidationError{Fields: map[string]string{"name": "a project with this name already exists"}}
+	}
+	return nil
+}
+
+// checkProjectRef returns a *ValidationError unless projectID is nil, 0 (no
+// project) or one of the projects in the user's space.
+func (u *User) checkProjectRef(projectID *int) error {
+	if projectID == nil || *projectID == 0 {
+		return nil
+	}
+	var count int
+	cmd := `SELECT COUNT(*) FROM projects WHERE id = ? AND user_id = ? AND ` + inWorkspace("projects")
+	if err := Db.QueryRow(cmd, *projectID, u.ID, u.workspaceID).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return &ValidationError{Fields: map[string]string{"projectId": "unknown project"}}
+	}
+	return nil
+}
+
+// nullableID stores a nil or zero id as NULL.
+func nullableID(id *int) interface{} {
+	if id == nil || *id == 0 {
+		return nil
+	}
+	return *id
+}
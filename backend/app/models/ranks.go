@@ -0,0 +1,194 @@
+package models
+
+import (
+	"database/sql"
+	"log"
+)
+
+// rankStep is the gap left between neighbouring ranks when a todo is
+// appended or a column is renumbered. Halving it leaves room for about 50
+// moves into the same gap before the column has to be renumbered.
+const rankStep = 1024
+
+// appendRank is the SQL expression giving a new todo a rank after all of the
+// user's existing todos. Its arguments are rankStep and the user id.
+const appendRank = `(SELECT COALESCE(MAX(rank), 0) + ? FROM todos WHERE user_id = ?)`
+
+// rankScope selects the live top-level todos of one board column: a user,
+// workspace (0 for the personal space), status and project (NULL for none,
+// see nullableID). Manual order is kept per column. Status and project are
+// compared bare so the (user_id, status, project_id, rank) index applies.
+const rankScope = `user_id = ? AND COALESCE(workspace_id, 0) = ? AND parent_id IS NULL AND deleted_at IS NULL
+	AND status = ? AND project_id IS ?`
+
+// TodoMove places a todo in manual order. AfterID is the todo to put it
+// right after, or 0 for the top of the column. The todo moves into the
+// column of AfterID, or into the one given by Status and ProjectID.
+type TodoMove struct {
+	AfterID   int
+	Status    *Status
+	ProjectID *int // 0 for todos without a project
+}
+
+type rankedTodo struct {
+	ID   int
+	Rank float64
+}
+
+// columnRanks returns the todos of a column in manual order, leaving out
+// exceptID.
+func (u *User) columnRanks(status Status, projectID, exceptID int) (todos []rankedTodo, err error) {
+	cmd := `SELECT id, rank FROM todos WHERE ` + rankScope + ` AND id != ? ORDER BY rank, id`
+	rows, err := Db.Query(cmd, u.ID, u.workspaceID, status, nullableID(&projectID), exceptID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t rankedTodo
+		if err = rows.Scan(&t.ID, &t.Rank); err != nil {
+			return nil, err
+		}
+		todos = append(todos, t)
+	}
+	return todos, rows.Err()
+}
+
+// MoveTodo puts one of the user's top-level todos right after another one,
+// or at the top of a column. Moving within a column only rewrites the moved
+// todo's rank; the rest of the column is renumbered only once the gap
+// between two neighbours is used up.
+func (u *User) MoveTodo(id int, m TodoMove) (todo Todo, err error) {
+	todo, err = u.GetTodo(id)
+	if err != nil {
+		return todo, err
+	}
+	invalid := func(field, message string) error {
+		return &ValidationError{Fields: map[string]string{field: message}}
+	}
+	if todo.ParentID != nil {
+		return todo, invalid("id", "subtasks are ordered through their parent")
+	}
+
+	status, projectID := todo.Status, 0
+	if todo.ProjectID != nil {
+		projectID = *todo.ProjectID
+	}
+	if m.AfterID != 0 {
+		if m.AfterID == id {
+			return todo, invalid("afterId", "a todo cannot be placed after itself")
+		}
+		after, err := u.GetTodo(m.AfterID)
+		if err == sql.ErrNoRows {
+			return todo, invalid("afterId", "unknown todo")
+		}
+		if err != nil {
+			return todo, err
+		}
+		if after.ParentID != nil {
+			return todo, invalid("afterId", "must be a top-level todo")
+		}
+		afterProject := 0
+		if after.ProjectID != nil {
+			afterProject = *after.ProjectID
+		}
+		if (m.Status != nil && *m.Status != after.Status) || (m.ProjectID != nil && *m.ProjectID != afterProject) {
+			return todo, invalid("afterId", "is in another column")
+		}
+		status, projectID = after.Status, afterProject
+	} else {
+		if m.Status != nil {
+			status = *m.Status
+		}
+		if m.ProjectID != nil {
+			projectID = *m.ProjectID
+		}
+	}
+
+	if status != todo.Status || projectID != idOrZero(todo.ProjectID) {
+		// Changing columns is an ordinary update, with its validation and
+		// history. Moving to another project without naming a column lets
+		// the todo carry its status over to that project's workflow.
+		patch := TodoPatch{ProjectID: &projectID}
+		if status != todo.Status {
+			patch.Status = &status
+		}
+		todo, err = u.PatchTodo(id, patch)
+		if err != nil {
+			return todo, err
+		}
+		status = todo.Status
+	}
+
+	column, err := u.columnRanks(status, projectID, id)
+	if err != nil {
+		log.Println("MoveTodo error:", err)
+		return todo, err
+	}
+	index := 0 // where the todo goes in column
+	if m.AfterID != 0 {
+		for i, t := range column {
+			if t.ID == m.AfterID {
+				index = i + 1
+			}
+		}
+	}
+
+	rank, ok := todo.Rank, true
+	switch {
+	case len(column) == 0:
+	case index == 0:
+		rank = column[0].Rank - rankStep
+	case index == len(column):
+		rank = column[index-1].Rank + rankStep
+	default:
+		prev, next := column[index-1].Rank, column[index].Rank
+		rank = prev + (next-prev)/2
+		ok = prev < rank && rank < next
+	}
+
+	if ok {
+		_, err = Db.Exec(`UPDATE todos SET rank = ? WHERE id = ? AND user_id = ?`, rank, id, u.ID)
+	} else {
+		err = renumberColumn(u.ID, column, index, id)
+	}
+	if err != nil {
+		log.Println("MoveTodo error:", err)
+		return todo, err
+	}
+	return u.GetTodo(id)
+}
+
+// renumberColumn spaces out the ranks of a column evenly, inserting id at
+// index.
+func renumberColumn(userID int, column []rankedTodo, index, id int) error {
+	ids := make([]int, 0, len(column)+1)
+	for _, t := range column[:index] {
+		ids = append(ids, t.ID)
+	}
+	ids = append(ids, id)
+	for _, t := range column[index:] {
+		ids = append(ids, t.ID)
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, todoID := range ids {
+		if _, err = tx.Exec(`UPDATE todos SET rank = ? WHERE id = ? AND user_id = ?`, (i+1)*rankStep, todoID, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func idOrZero(id *int) int {
+	if id == nil {
+		return 0
+	}
+	return *id
+}
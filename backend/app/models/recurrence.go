@@ -0,0 +1,477 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence is the supported subset of an RFC 5545 RRULE:
+//
+//	FREQ=DAILY|WEEKLY|MONTHLY|YEARLY  INTERVAL=n  BYDAY=MO,WE | 2TU,-1FR
+//	COUNT=n  UNTIL=YYYYMMDD
+//
+// Ordinal BYDAY entries such as 2TU ("second Tuesday") are only meaningful
+// with FREQ=MONTHLY. Weeks start on Monday.
+type Recurrence struct {
+	Freq     string
+	Interval int
+	ByDay    []RecurrenceDay
+	Count    int       // 0 means no limit
+	Until    time.Time // zero means no end date
+}
+
+// RecurrenceDay is one BYDAY entry. Ordinal is 0 for "every such weekday",
+// 1..5 for the nth one in the month and -1..-5 counting from the end.
+type RecurrenceDay struct {
+	Ordinal int
+	Weekday time.Weekday
+}
+
+var recurrenceWeekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// ParseRecurrence parses an RRULE value, with or without the "RRULE:"
+// prefix.
+func ParseRecurrence(rule string) (rec Recurrence, err error) {
+	rule = strings.TrimSpace(rule)
+	if len(rule) >= 6 && strings.EqualFold(rule[:6], "RRULE:") {
+		rule = rule[6:]
+	}
+	rec.Interval = 1
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(rule, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		key = strings.ToUpper(strings.TrimSpace(key))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || value == "" {
+			return rec, fmt.Errorf("malformed part %q", part)
+		}
+		if seen[key] {
+			return rec, fmt.Errorf("%s is given twice", key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				rec.Freq = value
+			default:
+				return rec, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			if rec.Interval, err = strconv.Atoi(value); err != nil || rec.Interval < 1 {
+				return rec, fmt.Errorf("INTERVAL must be a positive integer")
+			}
+		case "COUNT":
+			if rec.Count, err = strconv.Atoi(value); err != nil || rec.Count < 1 {
+				return rec, fmt.Errorf("COUNT must be a positive integer")
+			}
+		case "UNTIL":
+			if rec.Until, err = parseRecurrenceUntil(value); err != nil {
+				return rec, fmt.Errorf("UNTIL must be a date like 20241231")
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				d, err := parseRecurrenceDay(day)
+				if err != nil {
+					return rec, err
+				}
+				rec.ByDay = append(rec.ByDay, d)
+			}
+		default:
+			return rec, fmt.Errorf("unsupported rule part %s", key)
+		}
+	}
+
+	if rec.Freq == "" {
+		return rec, fmt.Errorf("FREQ is required")
+	}
+	if rec.Count > 0 && !rec.Until.IsZero() {
+		return rec, fmt.Errorf("COUNT and UNTIL cannot be combined")
+	}
+	for _, d := range rec.ByDay {
+		if d.Ordinal != 0 && rec.Freq != "MONTHLY" {
+			return rec, fmt.Errorf("numbered BYDAY entries need FREQ=MONTHLY")
+		}
+	}
+	if len(rec.ByDay) > 0 && rec.Freq == "YEARLY" {
+		return rec, fmt.Errorf("BYDAY is not supported with FREQ=YEARLY")
+	}
+	return rec, nil
+}
+
+func parseRecurrenceUntil(value string) (time.Time, error) {
+	if len(value) > 8 {
+		// Date-time form, e.g. 20241231T235959Z; only the date matters for
+		// date-based todos.
+		value = value[:8]
+	}
+	return time.Parse("20060102", value)
+}
+
+func parseRecurrenceDay(s string) (d RecurrenceDay, err error) {
+	if len(s) < 2 {
+		return d, fmt.Errorf("unknown BYDAY entry %q", s)
+	}
+	weekday, ok := recurrenceWeekdays[s[len(s)-2:]]
+	if !ok {
+		return d, fmt.Errorf("unknown BYDAY entry %q", s)
+	}
+	d.Weekday = weekday
+	if prefix := s[:len(s)-2]; prefix != "" {
+		d.Ordinal, err = strconv.Atoi(prefix)
+		if err != nil || d.Ordinal == 0 || d.Ordinal < -5 || d.Ordinal > 5 {
+			return d, fmt.Errorf("unknown BYDAY entry %q", s)
+		}
+	}
+	return d, nil
+}
+
+// maxRecurrenceSteps bounds the search for the next date, so a rule that
+// can never match (say the 5th Monday every 12 months) cannot loop forever.
+const maxRecurrenceSteps = 1000
+
+// Next returns the first occurrence strictly after date, or ok=false when
+// the rule has none left before UNTIL. occurrence is the 1-based number of
+// the occurrence on date, used to honour COUNT.
+func (rec Recurrence) Next(date time.Time, occurrence int) (next time.Time, ok bool) {
+	if rec.Count > 0 && occurrence >= rec.Count {
+		return next, false
+	}
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch rec.Freq {
+	case "DAILY":
+		next, ok = rec.nextDaily(date)
+	case "WEEKLY":
+		next, ok = rec.nextWeekly(date)
+	case "MONTHLY":
+		next, ok = rec.nextMonthly(date)
+	case "YEARLY":
+		next, ok = rec.nextYearly(date)
+	}
+	if ok && !rec.Until.IsZero() && next.After(rec.Until) {
+		return next, false
+	}
+	return next, ok
+}
+
+func (rec Recurrence) matchesWeekday(d time.Time) bool {
+	if len(rec.ByDay) == 0 {
+		return true
+	}
+	for _, day := range rec.ByDay {
+		if day.Weekday == d.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func (rec Recurrence) nextDaily(date time.Time) (time.Time, bool) {
+	for i := 1; i <= maxRecurrenceSteps; i++ {
+		d := date.AddDate(0, 0, i*rec.Interval)
+		if rec.matchesWeekday(d) {
+			return d, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (rec Recurrence) nextWeekly(date time.Time) (time.Time, bool) {
+	if len(rec.ByDay) == 0 {
+		return date.AddDate(0, 0, 7*rec.Interval), true
+	}
+	// Rest of the current week first, then the first matching day of every
+	// interval-th week after it.
+	weekStart := date.AddDate(0, 0, -((int(date.Weekday()) + 6) % 7))
+	for d := date.AddDate(0, 0, 1); d.Before(weekStart.AddDate(0, 0, 7)); d = d.AddDate(0, 0, 1) {
+		if rec.matchesWeekday(d) {
+			return d, true
+		}
+	}
+	weekStart = weekStart.AddDate(0, 0, 7*rec.Interval)
+	for d := weekStart; d.Before(weekStart.AddDate(0, 0, 7)); d = d.AddDate(0, 0, 1) {
+		if rec.matchesWeekday(d) {
+			return d, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (rec Recurrence) nextMonthly(date time.Time) (time.Time, bool) {
+	monthStart := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= maxRecurrenceSteps; i++ {
+		month := monthStart.AddDate(0, i*rec.Interval, 0)
+		for _, d := range rec.monthDays(month, date.Day()) {
+			if d.After(date) {
+				return d, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// monthDays lists the matching days of the month starting at month, in
+// order. Without BYDAY that is the same day of the month as the series,
+// skipped in months that are too short.
+func (rec Recurrence) monthDays(month time.Time, dayOfMonth int) (days []time.Time) {
+	last := month.AddDate(0, 1, -1).Day()
+	if len(rec.ByDay) == 0 {
+		if dayOfMonth <= last {
+			days = append(days, month.AddDate(0, 0, dayOfMonth-1))
+		}
+		return days
+	}
+	for day := 1; day <= last; day++ {
+		d := month.AddDate(0, 0, day-1)
+		for _, by := range rec.ByDay {
+			if by.Weekday != d.Weekday() {
+				continue
+			}
+			nth, nthFromEnd := (day-1)/7+1, -((last-day)/7 + 1)
+			if by.Ordinal == 0 || by.Ordinal == nth || by.Ordinal == nthFromEnd {
+				days = append(days, d)
+				break
+			}
+		}
+	}
+	return days
+}
+
+func (rec Recurrence) nextYearly(date time.Time) (time.Time, bool) {
+	for i := 1; i <= maxRecurrenceSteps; i++ {
+		year := date.Year() + i*rec.Interval
+		d := time.Date(year, date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+		// 29 February only exists in leap years.
+		if d.Month() == date.Month() {
+			return d, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func validateRecurrence(v *ValidationError, rule string) {
+	if rule == "" {
+		return
+	}
+	if _, err := ParseRecurrence(rule); err != nil {
+		v.Add("recurrence", "must be a supported RRULE: "+err.Error())
+	}
+}
+
+// String formats rec as a canonical RRULE value.
+func (rec Recurrence) String() string {
+	parts := []string{"FREQ=" + rec.Freq}
+	if rec.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(rec.Interval))
+	}
+	if len(rec.ByDay) > 0 {
+		days := make([]string, 0, len(rec.ByDay))
+		for _, d := range rec.ByDay {
+			name := strings.ToUpper(d.Weekday.String()[:2])
+			if d.Ordinal != 0 {
+				name = strconv.Itoa(d.Ordinal) + name
+			}
+			days = append(days, name)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if rec.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(rec.Count))
+	}
+	if !rec.Until.IsZero() {
+		parts = append(parts, "UNTIL="+rec.Until.Format("20060102"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// normalizeRecurrence stores valid rules in canonical form and leaves
+// anything else for validation to reject.
+func normalizeRecurrence(rule string) string {
+	rec, err := ParseRecurrence(rule)
+	if err != nil {
+		return strings.TrimSpace(rule)
+	}
+	return rec.String()
+}
+
+// createNextOccurrence adds the todo that follows t in its series, once t
+// is done. Tags, subtasks and reminders relative to the due date are
+// copied; the copies start out in the first column of their workflow.
+func (u *User) createNextOccurrence(t Todo) error {
+	if t.Recurrence == "" || !t.Done || t.SeriesID == nil {
+		return nil
+	}
+	rec, err := ParseRecurrence(t.Recurrence)
+	if err != nil {
+		return err
+	}
+	// Series are advanced in the owner's zone, whichever member completes
+	// an occurrence.
+	loc := userLocation(u.ID)
+	dueDate, dueTime := t.dueIn(loc)
+	due, err := time.Parse(DueDateLayout, dueDate)
+	if err != nil {
+		return err
+	}
+	next, ok := rec.Next(due, t.Occurrence)
+	if !ok {
+		return nil
+	}
+
+	// Reopening and completing an occurrence again must not fork the
+	// series.
+	var later int
+	cmd := `SELECT COUNT(*) FROM todos WHERE series_id = ? AND occurrence > ? AND user_id = ?`
+	if err = Db.QueryRow(cmd, *t.SeriesID, t.Occurrence, u.ID).Scan(&later); err != nil || later > 0 {
+		return err
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The next occurrence keeps the time of day in the owner's zone, even
+	// across a daylight saving change.
+	now, nextDue := time.Now().UTC(), next.Format(DueDateLayout)
+	nextAt, err := dueAt(nextDue, dueTime, loc)
+	if err != nil {
+		return err
+	}
+	wf, err := workflowFor(u.ID, idOrZero(t.ProjectID))
+	if err != nil {
+		return err
+	}
+	cmd = `INSERT INTO todos (content, description, user_id, workspace_id, priority, status, due_date, due_at, project_id,
+		parent_id, position, rank, recurrence, series_id, occurrence, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ` + appendRank + `, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(cmd, t.Content, t.Description, u.ID, nullableID(t.WorkspaceID), t.Priority, wf.Initial(), nextDue, nextAt, nullableID(t.ProjectID),
+		nullableID(t.ParentID), t.Position, rankStep, u.ID, t.Recurrence, *t.SeriesID, t.Occurrence+1, now, now)
+	if err != nil {
+		log.Println("createNextOccurrence error:", err)
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`INSERT INTO todo_tags (todo_id, tag_id) SELECT ?, tag_id FROM todo_tags WHERE todo_id = ?`, id, t.ID); err != nil {
+		log.Println("createNextOccurrence error:", err)
+		return err
+	}
+	if err = copyOffsetReminders(tx, t.ID, int(id)); err != nil {
+		log.Println("createNextOccurrence error:", err)
+		return err
+	}
+	cmd = `INSERT INTO todos (content, description, user_id, workspace_id, priority, status, due_date, project_id, parent_id, position, rank,
+		created_at, updated_at)
+	SELECT content, description, user_id, workspace_id, priority, ` + initialStatusSQL + `, ?, project_id, ?, position, rank, ?, ?
+	FROM todos WHERE parent_id = ? AND user_id = ? AND deleted_at IS NULL`
+	if _, err = tx.Exec(cmd, nextDue, id, now, now, t.ID, u.ID); err != nil {
+		log.Println("createNextOccurrence error:", err)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	created, err := u.GetTodo(int(id))
+	if err != nil {
+		return err
+	}
+	if err = u.recordTodoChange(HistoryCreated, nil, &created); err != nil {
+		return err
+	}
+	for i := range created.Subtasks {
+		if err = u.recordTodoChange(HistoryCreated, nil, &created.Subtasks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SkipOccurrence moves one of the user's recurring todos on to the next
+// occurrence of its series without completing it.
+func (u *User) SkipOccurrence(id int) (todo Todo, err error) {
+	todo, err = u.GetTodo(id)
+	if err != nil {
+		return todo, err
+	}
+	if todo.Recurrence == "" {
+		return todo, &ValidationError{Fields: map[string]string{"recurrence": "the todo does not recur"}}
+	}
+	rec, err := ParseRecurrence(todo.Recurrence)
+	if err != nil {
+		return todo, err
+	}
+	loc := userLocation(u.ID)
+	dueDate, dueTime := todo.dueIn(loc)
+	due, err := time.Parse(DueDateLayout, dueDate)
+	if err != nil {
+		return todo, err
+	}
+	next, ok := rec.Next(due, todo.Occurrence)
+	if !ok {
+		return todo, &ValidationError{Fields: map[string]string{"recurrence": "the series has no further occurrences"}}
+	}
+
+	nextDue := next.Format(DueDateLayout)
+	nextAt, err := dueAt(nextDue, dueTime, loc)
+	if err != nil {
+		return todo, err
+	}
+	cmd := `UPDATE todos SET due_date = ?, due_at = ?, occurrence = occurrence + 1 WHERE id = ? AND user_id = ?`
+	if _, err = Db.Exec(cmd, nextDue, nextAt, id, u.ID); err != nil {
+		log.Println("SkipOccurrence error:", err)
+		return todo, err
+	}
+	if err = refreshFireAt(Db, `reminders.todo_id = ?`, id); err != nil {
+		return todo, err
+	}
+	skipped, err := u.GetTodo(id)
+	if err != nil {
+		return skipped, err
+	}
+	err = u.recordTodoChange(HistoryUpdated, &todo, &skipped)
+	return skipped, err
+}
+
+// EndSeries stops the series of one of the user's todos from recurring.
+// Existing occurrences are kept.
+func (u *User) EndSeries(id int) (todo Todo, err error) {
+	todo, err = u.GetTodo(id)
+	if err != nil {
+		return todo, err
+	}
+	if todo.SeriesID == nil {
+		return todo, nil
+	}
+	cmd := `SELECT id FROM todos WHERE series_id = ? AND user_id = ? AND recurrence != ''`
+	ended, err := queryIDs(cmd, *todo.SeriesID, u.ID)
+	if err != nil {
+		log.Println("EndSeries error:", err)
+		return todo, err
+	}
+	cmd = `UPDATE todos SET recurrence = '' WHERE series_id = ? AND user_id = ?`
+	if _, err = Db.Exec(cmd, *todo.SeriesID, u.ID); err != nil {
+		log.Println("EndSeries error:", err)
+		return todo, err
+	}
+	changes := []FieldChange{{Field: "recurrence", Old: todo.Recurrence, New: ""}}
+	for _, endedID := range ended {
+		if err = recordHistory(endedID, u.ID, u.actor(), HistoryUpdated, changes); err != nil {
+			return todo, err
+		}
+	}
+	return u.GetTodo(id)
+}
@@ -0,0 +1,348 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ReminderStatus string
+
+const (
+	ReminderPending ReminderStatus = "pending"
+	ReminderSent    ReminderStatus = "sent"
+	ReminderFailed  ReminderStatus = "failed" // gave up after too many attempts
+)
+
+// MaxReminderOffset is how far before the due date an offset reminder may
+// fire, in minutes.
+const MaxReminderOffset = 366 * 24 * 60
+
+// Reminder fires once, either at RemindAt or OffsetMinutes before the todo
+// is due, and is sent through the Delivery registered for Channel.
+type Reminder struct {
+	ID            int            `json:"id"`
+	UserID        int            `json:"user_id"`
+	TodoID        int            `json:"todo_id"`
+	RemindAt      *time.Time     `json:"remind_at"`
+	OffsetMinutes *int           `json:"offset_minutes"`
+	FireAt        time.Time      `json:"fire_at"`
+	Channel       string         `json:"channel"`
+	Status        ReminderStatus `json:"status"`
+	Attempts      int            `json:"attempts"`
+	LastError     string         `json:"last_error,omitempty"`
+	SentAt        *time.Time     `json:"sent_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+// reminderColumns and reminderTables make up the select matching
+// scanReminder; the todo is joined so that reminders of done and trashed
+// todos can be held back.
+const (
+	reminderColumns = `reminders.id, reminders.user_id, reminders.todo_id, reminders.remind_at,
+		reminders.offset_minutes, reminders.fire_at, reminders.channel, reminders.status, reminders.attempts,
+		reminders.last_error, reminders.sent_at, reminders.created_at`
+	reminderTables = `reminders
+		JOIN todos ON todos.id = reminders.todo_id`
+)
+
+func scanReminder(row interface{ Scan(...interface{}) error }, r *Reminder) error {
+	var fireAt *time.Time
+	err := row.Scan(&r.ID, &r.UserID, &r.TodoID, &r.RemindAt, &r.OffsetMinutes, &fireAt, &r.Channel, &r.Status,
+		&r.Attempts, &r.LastError, &r.SentAt, &r.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if fireAt != nil {
+		r.FireAt = *fireAt
+	}
+	return nil
+}
+
+// queryExecer is what *sql.DB and *sql.Tx have in common.
+type queryExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// refreshFireAt stores when the reminders matching where fire. Offset
+// reminders depend on the todo's due date and, for all-day todos, on the
+// time zone of the user they remind, so this runs whenever one of those
+// changes.
+func refreshFireAt(q queryExecer, where string, args ...interface{}) error {
+	cmd := `SELECT reminders.id, reminders.remind_at, reminders.offset_minutes,
+		COALESCE(todos.due_date, date('now')), todos.due_at, users.time_zone
+	FROM reminders
+		JOIN todos ON todos.id = reminders.todo_id
+		JOIN users ON users.id = reminders.user_id
+	WHERE ` + where
+	rows, err := q.Query(cmd, args...)
+	if err != nil {
+		log.Println("refreshFireAt error:", err)
+		return err
+	}
+	fireAt := make(map[int]*time.Time)
+	for rows.Next() {
+		var id int
+		var remindAt *time.Time
+		var offsetMinutes *int
+		var todo Todo
+		var user User
+		if err = rows.Scan(&id, &remindAt, &offsetMinutes, &todo.DueDate, &todo.DueAt, &user.TimeZone); err != nil {
+			rows.Close()
+			return err
+		}
+		// A reminder whose todo has no usable due date never fires.
+		fireAt[id] = nil
+		if remindAt != nil {
+			fireAt[id] = remindAt
+		} else if due, err := todo.dueStart(user.Location()); err == nil && offsetMinutes != nil {
+			at := due.Add(-time.Duration(*offsetMinutes) * time.Minute).UTC()
+			fireAt[id] = &at
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for id, at := range fireAt {
+		if _, err = q.Exec(`UPDATE reminders SET fire_at = ? WHERE id = ?`, at, id); err != nil {
+			log.Println("refreshFireAt error:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reminder) Validate() error {
+	v := &ValidationError{}
+	switch {
+	case r.RemindAt == nil && r.OffsetMinutes == nil:
+		v.Add("remindAt", "either remindAt or offsetMinutes is required")
+	case r.RemindAt != nil && r.OffsetMinutes != nil:
+		v.Add("remindAt", "cannot be combined with offsetMinutes")
+	case r.RemindAt != nil && !r.RemindAt.After(time.Now().UTC()):
+		v.Add("remindAt", "must be in the future")
+	case r.OffsetMinutes != nil && (*r.OffsetMinutes < 0 || *r.OffsetMinutes > MaxReminderOffset):
+		v.Add("offsetMinutes", fmt.Sprintf("must be between 0 and %d", MaxReminderOffset))
+	}
+	if _, ok := reminderDeliveries[r.Channel]; !ok {
+		channels := make([]string, 0, len(reminderDeliveries))
+		for name := range reminderDeliveries {
+			channels = append(channels, `"`+name+`"`)
+		}
+		sort.Strings(channels)
+		v.Add("channel", "must be one of "+strings.Join(channels, ", "))
+	}
+	return v.Err()
+}
+
+// CreateReminder adds a reminder for the user to a todo in their space. In
+// a workspace it reminds the member, not the owner. An empty channel means
+// the in-app inbox.
+func (u *User) CreateReminder(todoID int, remindAt *time.Time, offsetMinutes *int, channel string) (reminder Reminder, err error) {
+	if _, err = u.GetTodo(todoID); err != nil {
+		return reminder, err
+	}
+	if channel == "" {
+		channel = InboxChannel
+	}
+	if remindAt != nil {
+		utc := remindAt.UTC()
+		remindAt = &utc
+	}
+	reminder = Reminder{UserID: u.actor(), TodoID: todoID, RemindAt: remindAt, OffsetMinutes: offsetMinutes, Channel: channel}
+	if err = reminder.Validate(); err != nil {
+		return Reminder{}, err
+	}
+
+	cmd := `INSERT INTO reminders (user_id, todo_id, remind_at, offset_minutes, channel, status, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := Db.Exec(cmd, reminder.UserID, todoID, remindAt, offsetMinutes, channel, ReminderPending, time.Now().UTC())
+	if err != nil {
+		log.Println("CreateReminder error:", err)
+		return Reminder{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Reminder{}, err
+	}
+	if err = refreshFireAt(Db, `reminders.id = ?`, id); err != nil {
+		return Reminder{}, err
+	}
+	return u.GetReminder(int(id))
+}
+
+// GetReminder returns one of the user's reminders, or sql.ErrNoRows.
+func (u *User) GetReminder(id int) (reminder Reminder, err error) {
+	cmd := `SELECT ` + reminderColumns + ` FROM ` + reminderTables + `
+	WHERE reminders.id = ? AND reminders.user_id = ?`
+	err = scanReminder(Db.QueryRow(cmd, id, u.actor()), &reminder)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("GetReminder error:", err)
+	}
+	return reminder, err
+}
+
+// GetReminders lists the user's reminders on a todo in their space in the
+// order they fire.
+func (u *User) GetReminders(todoID int) (reminders []Reminder, err error) {
+	if _, err = u.GetTodo(todoID); err != nil {
+		return nil, err
+	}
+	cmd := `SELECT ` + reminderColumns + ` FROM ` + reminderTables + `
+	WHERE reminders.todo_id = ? AND reminders.user_id = ?
+	ORDER BY reminders.fire_at, reminders.id`
+	rows, err := Db.Query(cmd, todoID, u.actor())
+	if err != nil {
+		log.Println("GetReminders error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reminder Reminder
+		if err = scanReminder(rows, &reminder); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		reminders = append(reminders, reminder)
+	}
+	return reminders, rows.Err()
+}
+
+func (u *User) DeleteReminder(id int) error {
+	result, err := Db.Exec(`DELETE FROM reminders WHERE id = ? AND user_id = ?`, id, u.actor())
+	if err != nil {
+		log.Println("DeleteReminder error:", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// copyOffsetReminders gives the next occurrence of a recurring todo the
+// reminders that are relative to its due date.
+func copyOffsetReminders(tx *sql.Tx, fromTodoID, toTodoID int) error {
+	cmd := `INSERT INTO reminders (user_id, todo_id, offset_minutes, channel, status, created_at)
+	SELECT user_id, ?, offset_minutes, channel, ?, ? FROM reminders
+	WHERE todo_id = ? AND offset_minutes IS NOT NULL`
+	if _, err := tx.Exec(cmd, toTodoID, ReminderPending, time.Now().UTC(), fromTodoID); err != nil {
+		return err
+	}
+	return refreshFireAt(tx, `reminders.todo_id = ?`, toTodoID)
+}
+
+// dueRemindersQuery selects the pending reminders that fire at or before
+// the given time, using idx_reminders_due.
+var dueRemindersQuery = `SELECT ` + reminderColumns + ` FROM ` + reminderTables + `
+	WHERE reminders.status = ? AND reminders.fire_at <= ? AND NOT ` + todoDoneSQL + ` AND todos.deleted_at IS NULL
+	ORDER BY reminders.id`
+
+// DeliverDueReminders sends every pending reminder whose time has come.
+// Reminders of done todos wait until the todo is reopened, and those of
+// trashed todos until it is restored. A failed delivery is retried on later
+// runs until maxAttempts is reached.
+func DeliverDueReminders(maxAttempts int) (sent int, err error) {
+	rows, err := Db.Query(dueRemindersQuery, ReminderPending, time.Now().UTC())
+	if err != nil {
+		log.Println("DeliverDueReminders error:", err)
+		return 0, err
+	}
+	var due []Reminder
+	for rows.Next() {
+		var reminder Reminder
+		if err = scanReminder(rows, &reminder); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		due = append(due, reminder)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, reminder := range due {
+		deliverErr := deliverReminder(reminder)
+		if deliverErr == nil {
+			sent++
+			_, err = Db.Exec(`UPDATE reminders SET status = ?, attempts = attempts + 1, last_error = '', sent_at = ? WHERE id = ?`,
+				ReminderSent, time.Now().UTC(), reminder.ID)
+		} else {
+			log.Printf("Reminder %d delivery via %s failed: %v", reminder.ID, reminder.Channel, deliverErr)
+			status := ReminderPending
+			if reminder.Attempts+1 >= maxAttempts {
+				status = ReminderFailed
+			}
+			_, err = Db.Exec(`UPDATE reminders SET status = ?, attempts = attempts + 1, last_error = ? WHERE id = ?`,
+				status, deliverErr.Error(), reminder.ID)
+		}
+		if err != nil {
+			log.Println("DeliverDueReminders error:", err)
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func deliverReminder(reminder Reminder) error {
+	delivery, ok := reminderDeliveries[reminder.Channel]
+	if !ok {
+		return fmt.Errorf("no delivery registered for channel %q", reminder.Channel)
+	}
+	user, err := GetUser(reminder.UserID)
+	if err != nil {
+		return err
+	}
+	// The reminder's user may since have lost access to a workspace todo.
+	scope, err := user.TodoScope(reminder.TodoID, RoleViewer)
+	if err != nil {
+		return err
+	}
+	todo, err := scope.GetTodo(reminder.TodoID)
+	if err != nil {
+		return err
+	}
+	return delivery.Deliver(newReminderMessage(reminder, user, todo))
+}
+
+// StartReminderScheduler delivers due reminders every interval until stop
+// is called or the process exits. Reminders live in the database, so the
+// first run also catches up on anything that came due while the server was
+// down. stop waits for a run in progress to finish.
+func StartReminderScheduler(interval time.Duration, maxAttempts int) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		run := func() {
+			n, err := DeliverDueReminders(maxAttempts)
+			if err == nil && n > 0 {
+				log.Printf("Reminder scheduler delivered %d reminders", n)
+			}
+		}
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				run()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-stopped
+	}
+}
@@ -0,0 +1,248 @@
+package models
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStub is a local SMTP stand-in that accepts every message and hands
+// its envelope and data to the test.
+type smtpStub struct {
+	listener net.Listener
+	messages chan smtpMessage
+}
+
+type smtpMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+func startSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	stub := &smtpStub{listener: l, messages: make(chan smtpMessage, 10)}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go stub.serve(conn)
+		}
+	}()
+	return stub
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 localhost ESMTP stub")
+	var msg smtpMessage
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			c.PrintfLine("250 localhost")
+		case "MAIL":
+			msg = smtpMessage{From: addressIn(line)}
+			c.PrintfLine("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, addressIn(line))
+			c.PrintfLine("250 OK")
+		case "DATA":
+			c.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.Data = string(data)
+			s.messages <- msg
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 Bye")
+			return
+		default:
+			c.PrintfLine("250 OK")
+		}
+	}
+}
+
+func addressIn(line string) string {
+	start, end := strings.Index(line, "<"), strings.Index(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+func (s *smtpStub) delivery() *SMTPDelivery {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return &SMTPDelivery{Host: host, Port: port, From: "todo@localhost"}
+}
+
+// newDueReminder creates a reminder and moves its time into the past, as if
+// it had come due.
+func newDueReminder(t *testing.T, u User, todoID int, channel string) Reminder {
+	t.Helper()
+	at := time.Now().UTC().Add(time.Hour)
+	reminder, err := u.CreateReminder(todoID, &at, nil, channel)
+	if err != nil {
+		t.Fatalf("CreateReminder: %v", err)
+	}
+	past := time.Now().UTC().Add(-time.Minute)
+	if _, err = Db.Exec(`UPDATE reminders SET remind_at = ?, fire_at = ? WHERE id = ?`, past, past, reminder.ID); err != nil {
+		t.Fatalf("moving reminder: %v", err)
+	}
+	return reminder
+}
+
+// waitForReminder polls until the scheduler has settled the reminder.
+func waitForReminder(t *testing.T, u User, id int) Reminder {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reminder, err := u.GetReminder(id)
+		if err != nil {
+			t.Fatalf("GetReminder: %v", err)
+		}
+		if reminder.Status != ReminderPending || time.Now().After(deadline) {
+			return reminder
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReminderFireAtFollowsDueDate(t *testing.T) {
+	RegisterDelivery(InboxDelivery{})
+	u := newTestUser(t)
+	todo, err := u.CreateTodo(Todo{Content: "Pay rent", DueDate: "2030-01-15"})
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	offset := 60
+	reminder, err := u.CreateReminder(todo.ID, nil, &offset, InboxChannel)
+	if err != nil {
+		t.Fatalf("CreateReminder: %v", err)
+	}
+	fireAt := func(step string, want time.Time) {
+		t.Helper()
+		got, err := u.GetReminder(reminder.ID)
+		if err != nil {
+			t.Fatalf("GetReminder: %v", err)
+		}
+		if !got.FireAt.Equal(want) {
+			t.Errorf("%s: fire_at = %v, want %v", step, got.FireAt, want)
+		}
+	}
+	fireAt("created", time.Date(2030, 1, 14, 23, 0, 0, 0, time.UTC))
+
+	dueDate := "2030-01-20"
+	if _, err = u.PatchTodo(todo.ID, TodoPatch{DueDate: &dueDate}); err != nil {
+		t.Fatalf("PatchTodo: %v", err)
+	}
+	fireAt("due date moved", time.Date(2030, 1, 19, 23, 0, 0, 0, time.UTC))
+
+	// Midnight in Tokyo is 15:00 UTC the day before.
+	if err = u.UpdateTimeZone("Asia/Tokyo"); err != nil {
+		t.Fatalf("UpdateTimeZone: %v", err)
+	}
+	fireAt("time zone changed", time.Date(2030, 1, 19, 14, 0, 0, 0, time.UTC))
+
+	dueTime := "09:30"
+	if _, err = u.PatchTodo(todo.ID, TodoPatch{DueTime: &dueTime}); err != nil {
+		t.Fatalf("PatchTodo: %v", err)
+	}
+	fireAt("due time set", time.Date(2030, 1, 19, 23, 30, 0, 0, time.UTC))
+}
+
+func TestDueRemindersUseIndex(t *testing.T) {
+	plan := queryPlan(t, dueRemindersQuery, ReminderPending, time.Now().UTC())
+	if want := "USING INDEX idx_reminders_due (status=? AND fire_at<?)"; !strings.Contains(plan, want) {
+		t.Errorf("plan does not contain %q:\n%s", want, plan)
+	}
+}
+
+func TestReminderSchedulerDelivers(t *testing.T) {
+	stub := startSMTPStub(t)
+	RegisterDelivery(InboxDelivery{})
+	RegisterDelivery(stub.delivery())
+
+	u := newTestUser(t)
+	todo := newTestTodo(t, u, "Water the plants")
+	email := newDueReminder(t, u, todo.ID, EmailChannel)
+	inbox := newDueReminder(t, u, todo.ID, InboxChannel)
+
+	stop := StartReminderScheduler(time.Hour, 3)
+	defer stop()
+
+	select {
+	case msg := <-stub.messages:
+		if msg.From != "todo@localhost" || len(msg.To) != 1 || msg.To[0] != u.Email {
+			t.Errorf("envelope = %s -> %v, want todo@localhost -> %s", msg.From, msg.To, u.Email)
+		}
+		for _, want := range []string{"To: " + u.Email, "Subject: Reminder: Water the plants", `"Water the plants" is due on ` + todo.DueDate} {
+			if !strings.Contains(msg.Data, want) {
+				t.Errorf("message does not contain %q:\n%s", want, msg.Data)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email reached the SMTP stub")
+	}
+
+	for _, r := range []Reminder{email, inbox} {
+		if got := waitForReminder(t, u, r.ID); got.Status != ReminderSent || got.SentAt == nil {
+			t.Errorf("%s reminder status = %s, sent_at %v, want sent", r.Channel, got.Status, got.SentAt)
+		}
+	}
+
+	notifications, err := u.GetNotifications(false)
+	if err != nil {
+		t.Fatalf("GetNotifications: %v", err)
+	}
+	if len(notifications) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(notifications))
+	}
+	n := notifications[0]
+	if n.ReminderID == nil || *n.ReminderID != inbox.ID || n.TodoID == nil || *n.TodoID != todo.ID || n.Title != "Reminder: Water the plants" {
+		t.Errorf("notification = %+v, want the inbox reminder for todo %d", n, todo.ID)
+	}
+}
+
+func TestReminderSchedulerCatchesUpAfterRestart(t *testing.T) {
+	RegisterDelivery(InboxDelivery{})
+	u := newTestUser(t)
+	todo := newTestTodo(t, u, "Renew passport")
+
+	// The first scheduler runs once and is stopped before the reminder
+	// comes due, like a server that goes down.
+	StartReminderScheduler(time.Hour, 3)()
+	reminder := newDueReminder(t, u, todo.ID, InboxChannel)
+	if got, _ := u.GetReminder(reminder.ID); got.Status != ReminderPending {
+		t.Fatalf("status before restart = %s, want pending", got.Status)
+	}
+
+	stop := StartReminderScheduler(time.Hour, 3)
+	defer stop()
+	if got := waitForReminder(t, u, reminder.ID); got.Status != ReminderSent {
+		t.Fatalf("status after restart = %s, want sent", got.Status)
+	}
+	notifications, err := u.GetNotifications(false)
+	if err != nil {
+		t.Fatalf("GetNotifications: %v", err)
+	}
+	if len(notifications) != 1 || *notifications[0].ReminderID != reminder.ID {
+		t.Errorf("notifications = %+v, want one for reminder %d", notifications, reminder.ID)
+	}
+}
@@ -4,4 +4,5 @@ type SignupRequest struct {
 	Name     string `json:"name"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	TimeZone string `json:"timeZone"` // optional, defaults to UTC
 }
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// StatusChange is one move of a todo between workflow columns. From is
+// empty for the column a todo was created in.
+type StatusChange struct {
+	ID        int       `json:"id"`
+	TodoID    int       `json:"todo_id"`
+	ActorID   int       `json:"actor_id"`
+	ActorName string    `json:"actor_name"`
+	From      Status    `json:"from"`
+	To        Status    `json:"to"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// completedAtUpdate keeps completed_at in step with the status: set when a
+// todo first enters a done column, kept while it moves between done
+// columns and cleared when it is reopened. Its argument is the time of the
+// change. It must run after the status itself was written.
+var completedAtUpdate = `completed_at = CASE WHEN ` + todoDoneSQL + ` THEN COALESCE(completed_at, ?) ELSE NULL END`
+
+// stampTodo sets updated_at to now after a recorded change and, when the
+// change touched the status, appends it to the status log and updates
+// completed_at.
+func stampTodo(todoID, userID, actorID int, changes []FieldChange, now time.Time) error {
+	sets := "updated_at = ?"
+	args := []interface{}{now}
+	for _, c := range changes {
+		if c.Field != "status" {
+			continue
+		}
+		cmd := `INSERT INTO todo_status_changes (todo_id, user_id, actor_id, from_status, to_status, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+		if _, err := Db.Exec(cmd, todoID, userID, actorID, c.Old, c.New, now); err != nil {
+			log.Println("stampTodo error:", err)
+			return err
+		}
+		sets += ", " + completedAtUpdate
+		args = append(args, now)
+	}
+	args = append(args, todoID)
+	if _, err := Db.Exec(`UPDATE todos SET `+sets+` WHERE id = ?`, args...); err != nil {
+		log.Println("stampTodo error:", err)
+		return err
+	}
+	return nil
+}
+
+// GetStatusChanges returns the status log of a todo in the user's space,
+// oldest first, so that the time spent in each column can be read off
+// between consecutive entries.
+func (u *User) GetStatusChanges(todoID int) (changes []StatusChange, err error) {
+	var count int
+	cmd := `SELECT COUNT(*) FROM todos WHERE id = ? AND user_id = ? AND ` + inWorkspace("todos")
+	if err = Db.QueryRow(cmd, todoID, u.ID, u.workspaceID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	cmd = `SELECT todo_status_changes.id, todo_status_changes.todo_id, todo_status_changes.actor_id, COALESCE(users.name, ''),
+		COALESCE(todo_status_changes.from_status, ''), todo_status_changes.to_status, todo_status_changes.created_at
+	FROM todo_status_changes LEFT JOIN users ON users.id = todo_status_changes.actor_id
+	WHERE todo_status_changes.todo_id = ? ORDER BY todo_status_changes.id`
+	rows, err := Db.Query(cmd, todoID)
+	if err != nil {
+		log.Println("GetStatusChanges error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c StatusChange
+		if err = rows.Scan(&c.ID, &c.TodoID, &c.ActorID, &c.ActorName, &c.From, &c.To, &c.CreatedAt); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Progress is the completion roll-up of a todo's subtasks.
+type Progress struct {
+	Done  int
+	Total int
+}
+
+func (p Progress) String() string {
+	return fmt.Sprintf("%d/%d done", p.Done, p.Total)
+}
+
+// checkParentRef returns a *ValidationError unless parentID is nil, 0 (top
+// level) or a top-level todo in the user's space other than todoID. Subtasks
+// only go one level deep, so a todo that has subtasks cannot become one
+// itself.
+func (u *User) checkParentRef(parentID *int, todoID int) error {
+	if parentID == nil || *parentID == 0 {
+		return nil
+	}
+	invalid := func(message string) error {
+		return &ValidationError{Fields: map[string]string{"parentId": message}}
+	}
+	if *parentID == todoID {
+		return invalid("a todo cannot be its own parent")
+	}
+
+	var grandparent sql.NullInt64
+	cmd := `SELECT parent_id FROM todos WHERE id = ? AND user_id = ? AND ` + inWorkspace("todos") + ` AND deleted_at IS NULL`
+	err := Db.QueryRow(cmd, *parentID, u.ID, u.workspaceID).Scan(&grandparent)
+	if err == sql.ErrNoRows {
+		return invalid("unknown todo")
+	}
+	if err != nil {
+		return err
+	}
+	if grandparent.Valid {
+		return invalid("subtasks cannot have subtasks of their own")
+	}
+
+	if todoID != 0 {
+		var children int
+		cmd = `SELECT COUNT(*) FROM todos WHERE parent_id = ? AND user_id = ? AND deleted_at IS NULL`
+		if err = Db.QueryRow(cmd, todoID, u.ID).Scan(&children); err != nil {
+			return err
+		}
+		if children > 0 {
+			return invalid("a todo with subtasks cannot become a subtask")
+		}
+	}
+	return nil
+}
+
+// nextPosition returns the position a todo gets when it is appended to
+// parentID's subtasks, or 0 for top-level todos.
+func nextPosition(userID int, parentID *int) (position int, err error) {
+	if parentID == nil || *parentID == 0 {
+		return 0, nil
+	}
+	cmd := `SELECT COALESCE(MAX(position), 0) + 1 FROM todos WHERE parent_id = ? AND user_id = ?`
+	err = Db.QueryRow(cmd, *parentID, userID).Scan(&position)
+	return position, err
+}
+
+// loadSubtasks nests the subtasks of each todo under it in position order
+// and fills in the Progress roll-up. Live todos get their live subtasks and
+// trashed ones the subtasks that were trashed with them.
+func loadSubtasks(todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(todos))
+	args := make([]interface{}, 0, len(todos))
+	for i := range todos {
+		index[todos[i].ID] = i
+		args = append(args, todos[i].ID)
+	}
+
+	cmd := `SELECT ` + todoColumns + ` FROM todos
+	WHERE todos.parent_id IN (` + placeholders(len(args)) + `)
+		AND (todos.deleted_at IS NULL) = (SELECT parent.deleted_at IS NULL FROM todos parent WHERE parent.id = todos.parent_id)
+	ORDER BY todos.position, todos.id`
+	rows, err := Db.Query(cmd, args...)
+	if err != nil {
+		log.Println("loadSubtasks error:", err)
+		return err
+	}
+	defer rows.Close()
+
+	var subtasks []Todo
+	for rows.Next() {
+		var todo Todo
+		if err = rows.Scan(todoFields(&todo)...); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		subtasks = append(subtasks, todo)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if err = loadTodoTags(subtasks); err != nil {
+		return err
+	}
+	if err = annotateDone(subtasks); err != nil {
+		return err
+	}
+
+	for _, sub := range subtasks {
+		parent := &todos[index[*sub.ParentID]]
+		parent.Subtasks = append(parent.Subtasks, sub)
+		if parent.Progress == nil {
+			parent.Progress = &Progress{}
+		}
+		parent.Progress.Total++
+		if sub.Done {
+			parent.Progress.Done++
+		}
+	}
+	return nil
+}
+
+// CompleteSubtasks moves every unfinished subtask of one of the user's todos
+// into the first done column of its workflow and returns the parent as
+// stored.
+func (u *User) CompleteSubtasks(parentID int) (todo Todo, err error) {
+	parent, err := u.GetTodo(parentID)
+	if err != nil {
+		return todo, err
+	}
+	for _, sub := range parent.Subtasks {
+		if sub.Done {
+			continue
+		}
+		wf, err := workflowFor(u.ID, idOrZero(sub.ProjectID))
+		if err != nil {
+			return todo, err
+		}
+		status := wf.DoneStatus()
+		cmd := `UPDATE todos SET status = ? WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+		if _, err = Db.Exec(cmd, status, sub.ID, u.ID); err != nil {
+			log.Println("CompleteSubtasks error:", err)
+			return todo, err
+		}
+		changes := []FieldChange{{Field: "status", Old: string(sub.Status), New: string(status)}}
+		if err = recordHistory(sub.ID, u.ID, u.actor(), HistoryUpdated, changes); err != nil {
+			return todo, err
+		}
+	}
+	return u.GetTodo(parentID)
+}
+
+// ReorderSubtasks puts the subtasks of parentID in the order of ids, which
+// must list each of them exactly once.
+func (u *User) ReorderSubtasks(parentID int, ids []int) (todo Todo, err error) {
+	parent, err := u.GetTodo(parentID)
+	if err != nil {
+		return todo, err
+	}
+	current := make(map[int]bool, len(parent.Subtasks))
+	for _, sub := range parent.Subtasks {
+		current[sub.ID] = true
+	}
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if !current[id] || seen[id] {
+			break
+		}
+		seen[id] = true
+	}
+	if len(seen) != len(ids) || len(ids) != len(current) {
+		return todo, &ValidationError{Fields: map[string]string{"ids": "must list every subtask exactly once"}}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return todo, err
+	}
+	defer tx.Rollback()
+
+	for i, id := range ids {
+		cmd := `UPDATE todos SET position = ? WHERE id = ? AND parent_id = ? AND user_id = ?`
+		if _, err = tx.Exec(cmd, i+1, id, parentID, u.ID); err != nil {
+			log.Println("ReorderSubtasks error:", err)
+			return todo, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return todo, err
+	}
+	return u.GetTodo(parentID)
+}
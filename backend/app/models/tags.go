@@ -0,0 +1,274 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const MaxTagNameLength = 50
+
+// Tag is a label of a user's personal space or of a workspace. A todo can
+// carry any number of tags and a tag can be attached to any number of the
+// todos in the same space.
+type Tag struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	WorkspaceID *int      `json:"workspace_id"` // nil for personal tags
+	Name        string    `json:"name"`
+	Color       string    `json:"color"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (t *Tag) Validate() error {
+	v := &ValidationError{}
+	if strings.TrimSpace(t.Name) == "" {
+		v.Add("name", "must not be empty")
+	} else if utf8.RuneCountInString(t.Name) > MaxTagNameLength {
+		v.Add("name", fmt.Sprintf("must be at most %d characters", MaxTagNameLength))
+	}
+	if t.Color != "" && !projectColorPattern.MatchString(t.Color) {
+		v.Add("color", "must be a hex colour like #1e90ff")
+	}
+	return v.Err()
+}
+
+const tagColumns = `tags.id, tags.user_id, tags.workspace_id, tags.name, COALESCE(tags.color, ''), tags.created_at`
+
+func tagFields(t *Tag) []interface{} {
+	return []interface{}{&t.ID, &t.UserID, &t.WorkspaceID, &t.Name, &t.Color, &t.CreatedAt}
+}
+
+func (u *User) CreateTag(name, color string) (tag Tag, err error) {
+	tag = Tag{UserID: u.ID, WorkspaceID: u.workspaceRef(), Name: strings.TrimSpace(name), Color: color}
+	if err = tag.Validate(); err != nil {
+		return Tag{}, err
+	}
+	if err = u.checkTagNameFree(tag.Name, 0); err != nil {
+		return Tag{}, err
+	}
+
+	cmd := `INSERT INTO tags (user_id, workspace_id, name, color, created_at) VALUES (?, ?, ?, ?, ?)`
+	result, err := Db.Exec(cmd, u.ID, tag.WorkspaceID, tag.Name, tag.Color, time.Now().UTC())
+	if err != nil {
+		log.Println("CreateTag error:", err)
+		return Tag{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Tag{}, err
+	}
+	return u.GetTag(int(id))
+}
+
+// GetTag returns one of the tags in the user's space, or sql.ErrNoRows.
+func (u *User) GetTag(id int) (tag Tag, err error) {
+	cmd := `SELECT ` + tagColumns + ` FROM tags WHERE tags.id = ? AND tags.user_id = ? AND ` + inWorkspace("tags")
+	err = Db.QueryRow(cmd, id, u.ID, u.workspaceID).Scan(tagFields(&tag)...)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("GetTag error:", err)
+	}
+	return tag, err
+}
+
+func (u *User) GetTags() (tags []Tag, err error) {
+	cmd := `SELECT ` + tagColumns + ` FROM tags WHERE tags.user_id = ? AND ` + inWorkspace("tags") + ` ORDER BY lower(tags.name), tags.id`
+	rows, err := Db.Query(cmd, u.ID, u.workspaceID)
+	if err != nil {
+		log.Println("GetTags error:", err)
+		return tags, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag Tag
+		if err = rows.Scan(tagFields(&tag)...); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// UpdateTag renames or recolours t, which must belong to t.UserID and be in
+// the workspace given by t.WorkspaceID.
+func (t *Tag) UpdateTag() (tag Tag, err error) {
+	t.Name = strings.TrimSpace(t.Name)
+	if err = t.Validate(); err != nil {
+		return tag, err
+	}
+	owner := User{ID: t.UserID, workspaceID: idOrZero(t.WorkspaceID)}
+	if err = owner.checkTagNameFree(t.Name, t.ID); err != nil {
+		return tag, err
+	}
+
+	cmd := `UPDATE tags SET name = ?, color = ? WHERE id = ? AND user_id = ?`
+	result, err := Db.Exec(cmd, t.Name, t.Color, t.ID, t.UserID)
+	if err != nil {
+		log.Println("UpdateTag error:", err)
+		return tag, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return tag, sql.ErrNoRows
+	}
+	return owner.GetTag(t.ID)
+}
+
+// DeleteTag removes t and detaches it from every todo.
+func (t *Tag) DeleteTag() (err error) {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM tags WHERE id = ? AND user_id = ?`, t.ID, t.UserID)
+	if err != nil {
+		log.Println("DeleteTag error:", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err = tx.Exec(`DELETE FROM todo_tags WHERE tag_id = ?`, t.ID); err != nil {
+		log.Println("DeleteTag error:", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// MergeTag moves every todo tagged with sourceID over to targetID and then
+// deletes the source tag. Both tags must be in the user's space.
+func (u *User) MergeTag(sourceID, targetID int) (tag Tag, err error) {
+	if sourceID == targetID {
+		return tag, &ValidationError{Fields: map[string]string{"into": "cannot merge a tag into itself"}}
+	}
+	if _, err = u.GetTag(sourceID); err != nil {
+		return tag, err
+	}
+	if _, err = u.GetTag(targetID); err != nil {
+		if err == sql.ErrNoRows {
+			return tag, &ValidationError{Fields: map[string]string{"into": "unknown tag"}}
+		}
+		return tag, err
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return tag, err
+	}
+	defer tx.Rollback()
+
+	cmd := `INSERT OR IGNORE INTO todo_tags (todo_id, tag_id) SELECT todo_id, ? FROM todo_tags WHERE tag_id = ?`
+	if _, err = tx.Exec(cmd, targetID, sourceID); err != nil {
+		log.Println("MergeTag error:", err)
+		return tag, err
+	}
+	if _, err = tx.Exec(`DELETE FROM todo_tags WHERE tag_id = ?`, sourceID); err != nil {
+		log.Println("MergeTag error:", err)
+		return tag, err
+	}
+	if _, err = tx.Exec(`DELETE FROM tags WHERE id = ? AND user_id = ?`, sourceID, u.ID); err != nil {
+		log.Println("MergeTag error:", err)
+		return tag, err
+	}
+	if err = tx.Commit(); err != nil {
+		return tag, err
+	}
+	return u.GetTag(targetID)
+}
+
+// TagTodo attaches a tag to a todo, both in the user's space.
+// Tagging a todo twice is not an error.
+func (u *User) TagTodo(todoID, tagID int) (todo Todo, err error) {
+	if err = u.checkTodoAndTag(todoID, tagID); err != nil {
+		return todo, err
+	}
+	if _, err = Db.Exec(`INSERT OR IGNORE INTO todo_tags (todo_id, tag_id) VALUES (?, ?)`, todoID, tagID); err != nil {
+		log.Println("TagTodo error:", err)
+		return todo, err
+	}
+	return u.GetTodo(todoID)
+}
+
+// UntagTodo detaches a tag from a todo. Removing a tag the todo does not
+// carry is not an error.
+func (u *User) UntagTodo(todoID, tagID int) (todo Todo, err error) {
+	if err = u.checkTodoAndTag(todoID, tagID); err != nil {
+		return todo, err
+	}
+	if _, err = Db.Exec(`DELETE FROM todo_tags WHERE todo_id = ? AND tag_id = ?`, todoID, tagID); err != nil {
+		log.Println("UntagTodo error:", err)
+		return todo, err
+	}
+	return u.GetTodo(todoID)
+}
+
+// checkTodoAndTag reports sql.ErrNoRows unless both are in the user's
+// space.
+func (u *User) checkTodoAndTag(todoID, tagID int) error {
+	var count int
+	cmd := `SELECT (SELECT COUNT(*) FROM todos WHERE id = ? AND user_id = ? AND ` + inWorkspace("todos") + ` AND deleted_at IS NULL) +
+		(SELECT COUNT(*) FROM tags WHERE id = ? AND user_id = ? AND ` + inWorkspace("tags") + `)`
+	if err := Db.QueryRow(cmd, todoID, u.ID, u.workspaceID, tagID, u.ID, u.workspaceID).Scan(&count); err != nil {
+		return err
+	}
+	if count != 2 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (u *User) checkTagNameFree(name string, exceptID int) error {
+	var count int
+	cmd := `SELECT COUNT(*) FROM tags WHERE user_id = ? AND ` + inWorkspace("tags") + ` AND lower(name) = lower(?) AND id != ?`
+	if err := Db.QueryRow(cmd, u.ID, u.workspaceID, name, exceptID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return &ValidationError{Fields: map[string]string{"name": "a tag with this name already exists"}}
+	}
+	return nil
+}
+
+// loadTodoTags fills in Tags on each todo with a single query.
+func loadTodoTags(todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(todos))
+	args := make([]interface{}, 0, len(todos))
+	for i := range todos {
+		todos[i].Tags = []Tag{}
+		index[todos[i].ID] = i
+		args = append(args, todos[i].ID)
+	}
+
+	cmd := `SELECT todo_tags.todo_id, ` + tagColumns + ` FROM todo_tags
+	JOIN tags ON tags.id = todo_tags.tag_id
+	WHERE todo_tags.todo_id IN (` + placeholders(len(args)) + `)
+	ORDER BY lower(tags.name), tags.id`
+	rows, err := Db.Query(cmd, args...)
+	if err != nil {
+		log.Println("loadTodoTags error:", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var todoID int
+		var tag Tag
+		if err = rows.Scan(append([]interface{}{&todoID}, tagFields(&tag)...)...); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		if i, ok := index[todoID]; ok {
+			todos[i].Tags = append(todos[i].Tags, tag)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	// Embed the zone database so time zones work on hosts without one.
+	_ "time/tzdata"
+)
+
+const (
+	DefaultTimeZone = "UTC"
+	DueTimeLayout   = "15:04"
+)
+
+func validateTimeZone(v *ValidationError, name string) {
+	if _, err := time.LoadLocation(name); err != nil || name == "" || name == "Local" {
+		v.Add("timeZone", "must be an IANA time zone such as Europe/Berlin")
+	}
+}
+
+// Location returns the user's time zone, falling back to UTC.
+func (u *User) Location() *time.Location {
+	if loc, err := time.LoadLocation(u.TimeZone); err == nil && u.TimeZone != "" {
+		return loc
+	}
+	return time.UTC
+}
+
+// userLocation looks up the time zone of userID for code that only has the
+// id at hand.
+func userLocation(userID int) *time.Location {
+	user := User{ID: userID}
+	err := Db.QueryRow(`SELECT time_zone FROM users WHERE id = ?`, userID).Scan(&user.TimeZone)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("userLocation error:", err)
+	}
+	return user.Location()
+}
+
+// location is the time zone the user reads and enters due dates in. In a
+// workspace that is the acting member's own zone, not the owner's.
+func (u *User) location() *time.Location {
+	return userLocation(u.actor())
+}
+
+// storedDueDate is the date kept in todos.due_date for a todo due on
+// dueDate at the instant at, as read in u.location(). For timed todos it is
+// the date of the instant in the owner's zone, which UpdateTimeZone keeps
+// current and the date filters of ListTodos compare against.
+func (u *User) storedDueDate(dueDate string, at *time.Time) string {
+	if at == nil || u.actor() == u.ID {
+		return dueDate
+	}
+	return at.In(userLocation(u.ID)).Format(DueDateLayout)
+}
+
+// dueIn gives the due date and time of t as read in loc.
+func (t *Todo) dueIn(loc *time.Location) (dueDate, dueTime string) {
+	if t.DueAt == nil {
+		return t.DueDate, ""
+	}
+	at := t.DueAt.In(loc)
+	return at.Format(DueDateLayout), at.Format(DueTimeLayout)
+}
+
+// today is the current date in loc, in DueDateLayout.
+func today(loc *time.Location) string {
+	return time.Now().In(loc).Format(DueDateLayout)
+}
+
+// dueAt turns a due date and optional "HH:MM" time in loc into the UTC
+// instant stored in todos.due_at; all-day todos have none.
+func dueAt(dueDate, dueTime string, loc *time.Location) (*time.Time, error) {
+	if dueTime == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation(DueDateLayout+" "+DueTimeLayout, dueDate+" "+dueTime, loc)
+	if err != nil {
+		return nil, err
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// dueStart is the moment a todo falls due: its due time, or the start of
+// its due date in loc for all-day todos. Offset reminders count back from
+// it.
+func (t *Todo) dueStart(loc *time.Location) (time.Time, error) {
+	if t.DueAt != nil {
+		return *t.DueAt, nil
+	}
+	return time.ParseInLocation(DueDateLayout, t.DueDate, loc)
+}
+
+// annotateDue fills in DueTime, DueToday and Overdue from the stored due
+// date and instant, as seen from loc, and reads the due date of timed todos
+// in loc too. Done must already be set.
+func annotateDue(todos []Todo, loc *time.Location) {
+	now := time.Now()
+	day := today(loc)
+	for i := range todos {
+		t := &todos[i]
+		t.DueDate, t.DueTime = t.dueIn(loc)
+		t.DueToday = t.DueDate == day
+		if !t.Done {
+			if t.DueAt != nil {
+				t.Overdue = t.DueAt.Before(now)
+			} else {
+				t.Overdue = t.DueDate < day
+			}
+		}
+		annotateDue(t.Subtasks, loc)
+	}
+}
+
+// UpdateTimeZone changes the user's time zone. Timed todos keep their
+// instant, so their due dates are re-derived in the new zone.
+func (u *User) UpdateTimeZone(name string) (err error) {
+	v := &ValidationError{}
+	validateTimeZone(v, name)
+	if err = v.Err(); err != nil {
+		return err
+	}
+	loc, _ := time.LoadLocation(name)
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`UPDATE users SET time_zone = ? WHERE id = ?`, name, u.ID); err != nil {
+		log.Println("UpdateTimeZone error:", err)
+		return err
+	}
+	rows, err := tx.Query(`SELECT id, due_at FROM todos WHERE user_id = ? AND due_at IS NOT NULL`, u.ID)
+	if err != nil {
+		log.Println("UpdateTimeZone error:", err)
+		return err
+	}
+	dueDates := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var at time.Time
+		if err = rows.Scan(&id, &at); err != nil {
+			rows.Close()
+			return err
+		}
+		dueDates[id] = at.In(loc).Format(DueDateLayout)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for id, dueDate := range dueDates {
+		if _, err = tx.Exec(`UPDATE todos SET due_date = ? WHERE id = ?`, dueDate, id); err != nil {
+			log.Println("UpdateTimeZone error:", err)
+			return err
+		}
+	}
+	// Offset reminders on all-day todos fire at the start of the day where
+	// the reminded user is.
+	if err = refreshFireAt(tx, `reminders.user_id = ?`, u.ID); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	u.TimeZone = name
+	return nil
+}
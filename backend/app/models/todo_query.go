@@ -0,0 +1,341 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	DefaultTodoSort   = "created_at"
+	MaxTodoQueryLimit = 500
+)
+
+// todoSortKeys maps the public sort names to SQL expressions. Timestamps
+// are all stored in one format (see migration 22), so they sort as text and
+// the (user_id, created_at) index can serve the default order. Timed todos
+// sort after the all-day todos of the same date.
+var todoSortKeys = map[string]string{
+	"id":           "todos.id",
+	"created_at":   "todos.created_at",
+	"updated_at":   "todos.updated_at",
+	"completed_at": "COALESCE(todos.completed_at, '')", // open todos first
+	"due_date":     "COALESCE(todos.due_date, '') || COALESCE(strftime(' %H:%M', todos.due_at), '')",
+	"priority":     "CASE todos.priority WHEN 'high' THEN 0 WHEN 'medium' THEN 1 ELSE 2 END",
+	"content":      "lower(todos.content)",
+	"rank":         "todos.rank", // manual order, see MoveTodo
+}
+
+// TagMode says how TodoQuery.Tags is matched.
+type TagMode string
+
+const (
+	TagModeAny  TagMode = "any"  // carries at least one of the tags
+	TagModeAll  TagMode = "all"  // carries every one of the tags
+	TagModeNone TagMode = "none" // carries none of the tags
+)
+
+func (m TagMode) Valid() bool {
+	switch m {
+	case TagModeAny, TagModeAll, TagModeNone:
+		return true
+	}
+	return false
+}
+
+// TodoQuery describes a filtered, sorted and optionally paginated listing.
+// Zero values mean "no filter"; a zero Limit returns every matching todo.
+// Filters, sorting and paging apply to top-level todos, which carry their
+// subtasks nested, unless Flat lists subtasks as todos of their own.
+type TodoQuery struct {
+	Statuses      []Status
+	Priorities    []Priority
+	DueFrom       string     // inclusive, YYYY-MM-DD
+	DueTo         string     // inclusive, YYYY-MM-DD
+	Overdue       bool       // in the user's time zone, to the minute for timed todos
+	DueToday      bool       // in the user's time zone
+	CompletedFrom string     // inclusive, YYYY-MM-DD in the user's time zone; leaves out open todos
+	CompletedTo   string     // inclusive, YYYY-MM-DD in the user's time zone; leaves out open todos
+	UpdatedSince  *time.Time // exclusive
+	Search        string
+	ProjectID     *int // 0 lists todos without a project
+	Tags          []int
+	TagMode       TagMode // defaults to TagModeAny
+	Flat          bool
+	Sort          string
+	Desc          bool
+	Limit         int
+	Cursor        string
+}
+
+// todoCursor is the keyset position after the last returned row.
+type todoCursor struct {
+	Value interface{} `json:"v"`
+	ID    int         `json:"id"`
+}
+
+func encodeTodoCursor(c todoCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeTodoCursor(s string) (c todoCursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+func (q TodoQuery) Validate() error {
+	v := &ValidationError{}
+	for _, s := range q.Statuses {
+		if !s.Valid() {
+			v.Add("status", fmt.Sprintf("unknown status %q", s))
+		}
+	}
+	for _, p := range q.Priorities {
+		if !p.Valid() {
+			v.Add("priority", fmt.Sprintf("unknown priority %q", p))
+		}
+	}
+	if q.DueFrom != "" {
+		if _, err := time.Parse(DueDateLayout, q.DueFrom); err != nil {
+			v.Add("due_from", "must be a date in YYYY-MM-DD format")
+		}
+	}
+	if q.DueTo != "" {
+		if _, err := time.Parse(DueDateLayout, q.DueTo); err != nil {
+			v.Add("due_to", "must be a date in YYYY-MM-DD format")
+		}
+	}
+	if q.CompletedFrom != "" {
+		if _, err := time.Parse(DueDateLayout, q.CompletedFrom); err != nil {
+			v.Add("completed_from", "must be a date in YYYY-MM-DD format")
+		}
+	}
+	if q.CompletedTo != "" {
+		if _, err := time.Parse(DueDateLayout, q.CompletedTo); err != nil {
+			v.Add("completed_to", "must be a date in YYYY-MM-DD format")
+		}
+	}
+	if q.TagMode != "" && !q.TagMode.Valid() {
+		v.Add("tag_mode", `must be one of "any", "all", "none"`)
+	}
+	if q.Sort != "" {
+		if _, ok := todoSortKeys[q.Sort]; !ok {
+			keys := make([]string, 0, len(todoSortKeys))
+			for k := range todoSortKeys {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			v.Add("sort", "must be one of "+strings.Join(keys, ", "))
+		}
+	}
+	if q.Limit < 0 || q.Limit > MaxTodoQueryLimit {
+		v.Add("limit", fmt.Sprintf("must be between 1 and %d", MaxTodoQueryLimit))
+	}
+	if q.Cursor != "" {
+		c, err := decodeTodoCursor(q.Cursor)
+		switch c.Value.(type) {
+		case string, float64:
+		default:
+			err = fmt.Errorf("unexpected cursor value %T", c.Value)
+		}
+		if err != nil {
+			v.Add("cursor", "is invalid")
+		}
+	}
+	return v.Err()
+}
+
+// ListTodos returns the todos of the user's space matching q. nextCursor
+// is non-empty when q.Limit cut the result short; pass it back as q.Cursor
+// for the next page.
+func (u *User) ListTodos(q TodoQuery) (todos []Todo, nextCursor string, err error) {
+	if err = q.Validate(); err != nil {
+		return nil, "", err
+	}
+	cmd, args := u.todoListQuery(q)
+	rows, err := Db.Query(cmd, args...)
+	if err != nil {
+		log.Println("ListTodos error:", err)
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var sortKeys []interface{}
+	for rows.Next() {
+		var todo Todo
+		var sortKey interface{}
+		if err = rows.Scan(append(todoFields(&todo), &sortKey)...); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		switch k := sortKey.(type) {
+		case []byte:
+			sortKey = string(k)
+		case int64:
+			sortKey = float64(k)
+		case time.Time:
+			// Compared against the column as stored.
+			sortKey = k.UTC().Format(sqlite3.SQLiteTimestampFormats[0])
+		}
+		todos = append(todos, todo)
+		sortKeys = append(sortKeys, sortKey)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if q.Limit > 0 && len(todos) > q.Limit {
+		todos = todos[:q.Limit]
+		last := todos[len(todos)-1]
+		nextCursor = encodeTodoCursor(todoCursor{Value: sortKeys[q.Limit-1], ID: last.ID})
+	}
+	loc := u.location()
+	if q.Flat {
+		if err = loadTodoTags(todos); err == nil {
+			err = annotateDone(todos)
+		}
+		if err == nil {
+			annotateDue(todos, loc)
+		}
+	} else {
+		err = loadTodoDetails(todos, loc)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return todos, nextCursor, nil
+}
+
+// todoListQuery builds the SQL of ListTodos for a validated q. Filters and
+// sorts use the bare columns where they can, so SQLite can use the listing
+// indexes instead of scanning the user's todos.
+func (u *User) todoListQuery(q TodoQuery) (cmd string, args []interface{}) {
+	if q.Sort == "" {
+		q.Sort = DefaultTodoSort
+	}
+	sortExpr := todoSortKeys[q.Sort]
+
+	loc := u.location()
+	day := today(loc)
+	where := []string{"todos.user_id = ?", inWorkspace("todos"), "todos.deleted_at IS NULL"}
+	args = []interface{}{u.ID, u.workspaceID}
+	if !q.Flat {
+		where = append(where, "todos.parent_id IS NULL")
+	}
+
+	if len(q.Statuses) > 0 {
+		where = append(where, "todos.status IN ("+placeholders(len(q.Statuses))+")")
+		for _, s := range q.Statuses {
+			args = append(args, s)
+		}
+	}
+	if len(q.Priorities) > 0 {
+		where = append(where, "todos.priority IN ("+placeholders(len(q.Priorities))+")")
+		for _, p := range q.Priorities {
+			args = append(args, p)
+		}
+	}
+	if q.DueFrom != "" {
+		where = append(where, "todos.due_date >= ?")
+		args = append(args, q.DueFrom)
+	}
+	if q.DueTo != "" {
+		where = append(where, "todos.due_date <= ?")
+		args = append(args, q.DueTo)
+	}
+	if q.Overdue {
+		where = append(where, `(CASE WHEN todos.due_at IS NULL THEN todos.due_date < ?
+			ELSE julianday(todos.due_at) < julianday('now') END) AND NOT `+todoDoneSQL)
+		args = append(args, day)
+	}
+	if q.DueToday {
+		where = append(where, "todos.due_date = ?")
+		args = append(args, day)
+	}
+	if q.CompletedFrom != "" {
+		from, _ := time.ParseInLocation(DueDateLayout, q.CompletedFrom, loc)
+		where = append(where, "todos.completed_at >= ?")
+		args = append(args, from.UTC())
+	}
+	if q.CompletedTo != "" {
+		to, _ := time.ParseInLocation(DueDateLayout, q.CompletedTo, loc)
+		where = append(where, "todos.completed_at < ?")
+		args = append(args, to.AddDate(0, 0, 1).UTC())
+	}
+	if q.UpdatedSince != nil {
+		where = append(where, "todos.updated_at > ?")
+		args = append(args, q.UpdatedSince.UTC())
+	}
+	if q.ProjectID != nil {
+		if *q.ProjectID == 0 {
+			where = append(where, "todos.project_id IS NULL")
+		} else {
+			where = append(where, "todos.project_id = ?")
+			args = append(args, *q.ProjectID)
+		}
+	}
+	if len(q.Tags) > 0 {
+		tagIDs := make([]interface{}, 0, len(q.Tags))
+		seen := make(map[int]bool)
+		for _, id := range q.Tags {
+			if !seen[id] {
+				seen[id] = true
+				tagIDs = append(tagIDs, id)
+			}
+		}
+		in := placeholders(len(tagIDs))
+		switch q.TagMode {
+		case TagModeAll:
+			where = append(where, "(SELECT COUNT(*) FROM todo_tags WHERE todo_tags.todo_id = todos.id AND todo_tags.tag_id IN ("+in+")) = ?")
+			args = append(append(args, tagIDs...), len(tagIDs))
+		case TagModeNone:
+			where = append(where, "todos.id NOT IN (SELECT todo_id FROM todo_tags WHERE tag_id IN ("+in+"))")
+			args = append(args, tagIDs...)
+		default:
+			where = append(where, "todos.id IN (SELECT todo_id FROM todo_tags WHERE tag_id IN ("+in+"))")
+			args = append(args, tagIDs...)
+		}
+	}
+	if q.Search != "" {
+		where = append(where, `todos.content LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(q.Search)+"%")
+	}
+
+	cmp, order := ">", "ASC"
+	if q.Desc {
+		cmp, order = "<", "DESC"
+	}
+	if q.Cursor != "" {
+		c, _ := decodeTodoCursor(q.Cursor)
+		where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND todos.id %[2]s ?))", sortExpr, cmp))
+		args = append(args, c.Value, c.Value, c.ID)
+	}
+
+	cmd = `SELECT ` + todoColumns + `, ` + sortExpr + ` AS sort_key FROM todos
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY sort_key ` + order + `, todos.id ` + order
+	if q.Limit > 0 {
+		// One extra row tells us whether there is another page.
+		cmd += fmt.Sprintf(" LIMIT %d", q.Limit+1)
+	}
+	return cmd, args
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+// queryPlan returns the EXPLAIN QUERY PLAN details of cmd, one per step.
+func queryPlan(t *testing.T, cmd string, args ...interface{}) string {
+	t.Helper()
+	rows, err := Db.Query(`EXPLAIN QUERY PLAN `+cmd, args...)
+	if err != nil {
+		t.Fatalf("EXPLAIN QUERY PLAN: %v", err)
+	}
+	defer rows.Close()
+	var steps []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err = rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		steps = append(steps, detail)
+	}
+	return strings.Join(steps, "\n")
+}
+
+func TestTodoListingsUseIndexes(t *testing.T) {
+	u := newTestUser(t)
+	// search is what the plan must look up in the index; sorted says the
+	// index order also serves ORDER BY.
+	tests := []struct {
+		name   string
+		q      TodoQuery
+		search string
+		sorted bool
+	}{
+		{"default order", TodoQuery{}, "idx_todos_user_created (user_id=?)", true},
+		{"newest first", TodoQuery{Desc: true, Limit: 20}, "idx_todos_user_created (user_id=?)", true},
+		{"status filter", TodoQuery{Statuses: []Status{StatusTodo, StatusInProgress}, Sort: "due_date"}, "idx_todos_user_status (user_id=? AND status=?)", false},
+		{"priority filter", TodoQuery{Priorities: []Priority{PriorityHigh}, Sort: "content"}, "idx_todos_user_priority (user_id=? AND priority=?)", false},
+		{"completed range", TodoQuery{CompletedFrom: "2030-01-01", CompletedTo: "2030-01-31", Sort: "content"},
+			"idx_todos_user_completed (user_id=? AND completed_at>? AND completed_at<?)", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, args := u.todoListQuery(tt.q)
+			plan := queryPlan(t, cmd, args...)
+			if !strings.Contains(plan, "SEARCH todos USING INDEX "+tt.search) {
+				t.Errorf("plan does not search %s:\n%s", tt.search, plan)
+			}
+			if tt.sorted && strings.Contains(plan, "TEMP B-TREE") {
+				t.Errorf("plan sorts in a temp B-tree:\n%s", plan)
+			}
+		})
+	}
+
+	t.Run("board column", func(t *testing.T) {
+		plan := queryPlan(t, `SELECT id FROM todos WHERE `+rankScope+` AND id != ? ORDER BY rank, id`, u.ID, 0, StatusInProgress, nil, 0)
+		if want := "idx_todos_user_rank (user_id=? AND status=? AND project_id=?)"; !strings.Contains(plan, want) || strings.Contains(plan, "TEMP B-TREE") {
+			t.Errorf("plan does not search %s in rank order:\n%s", want, plan)
+		}
+	})
+}
@@ -1,121 +1,551 @@
 package models
 
 import (
+	"database/sql"
 	"log"
+	"strings"
 	"time"
+
+	"todo_app/utils"
 )
 
 type Todo struct {
-	ID        int
-	Content   string
-	UserID    int
-	Priority  string    // "high", "medium", "low"
-	Status    string    // "todo", "completed"
-	DueDate   string    // Date as string (YYYY-MM-DD)
-	CreatedAt time.Time
+	ID          int
+	Content     string
+	Description string // Markdown notes
+	// DescriptionHTML is only filled in by RenderDescription, for clients
+	// that cannot render Markdown themselves.
+	DescriptionHTML string `json:"DescriptionHTML,omitempty"`
+	UserID          int    // the owner, for workspace todos the workspace owner
+	WorkspaceID     *int   // nil for personal todos
+	Priority        Priority
+	Status          Status
+	Done            bool       // whether Status is a done column of the todo's workflow, never stored
+	DueDate         string     // Date as string (YYYY-MM-DD), in the reading user's time zone
+	DueTime         string     // "HH:MM" in the reading user's time zone, empty for all-day todos
+	DueAt           *time.Time // DueDate and DueTime as a UTC instant, nil for all-day todos
+	DueToday        bool       // computed for the reading user's time zone, never stored
+	Overdue         bool       // computed for the reading user's time zone, never stored
+	ProjectID       *int       // nil when the todo is not in a project
+	ParentID        *int       // nil for top-level todos
+	Position        int        // order among the parent's subtasks
+	Rank            float64    // manual order within the todo's board column
+	Recurrence      string     // RRULE, empty for one-off todos
+	SeriesID        *int       // id of the first todo of a recurring series
+	Occurrence      int        // 1-based number within the series
+	Tags            []Tag
+	CommentCount    int
+	Subtasks        []Todo    `json:",omitempty"`
+	Progress        *Progress `json:",omitempty"` // nil without subtasks
+	CreatedAt       time.Time
+	UpdatedAt       time.Time  // last change to a field tracked in the history
+	CompletedAt     *time.Time // when the todo last moved into a done column, nil while it is not done
+	DeletedAt       *time.Time `json:",omitempty"` // set while the todo is in the trash
 }
 
-func (t *User) CreateTodo(content string, priority string, dueDate string) (err error) {
-	if priority == "" {
-		priority = "medium"
+// CreateTodo inserts t as a new todo in the user's space and returns it as
+// stored. Empty priority and due date get their defaults, today being taken
+// in the acting user's time zone, and an empty status is the first column of the
+// project's workflow. Invalid input, including a status the workflow or a
+// WIP limit does not allow, is reported as a *ValidationError.
+func (u *User) CreateTodo(t Todo) (todo Todo, err error) {
+	loc := u.location()
+	if t.Priority == "" {
+		t.Priority = PriorityMedium
+	}
+	if t.DueDate == "" {
+		t.DueDate = today(loc)
+	}
+	if t.Status, err = workflowStatus(u.ID, nil, idOrZero(t.ProjectID), &t.Status); err != nil {
+		return todo, err
+	}
+	t.Recurrence = normalizeRecurrence(t.Recurrence)
+	if err = t.Validate(); err != nil {
+		return todo, err
+	}
+	if t.DueAt, err = dueAt(t.DueDate, t.DueTime, loc); err != nil {
+		return todo, err
+	}
+	t.DueDate = u.storedDueDate(t.DueDate, t.DueAt)
+	if err = u.checkProjectRef(t.ProjectID); err != nil {
+		return todo, err
+	}
+	if err = u.checkParentRef(t.ParentID, 0); err != nil {
+		return todo, err
+	}
+	if err = u.checkWorkflow(nil, idOrZero(t.ProjectID), t.Status, idOrZero(t.ParentID) == 0); err != nil {
+		return todo, err
 	}
-	if dueDate == "" {
-		dueDate = time.Now().Format("2006-01-02")
+	position, err := nextPosition(u.ID, t.ParentID)
+	if err != nil {
+		return todo, err
 	}
 
 	cmd := `INSERT INTO todos (
 		content,
+		description,
 		user_id,
+		workspace_id,
 		priority,
 		status,
 		due_date,
-		created_at
-	) VALUES (?, ?, ?, ?, ?, ?)`
-	_, err = Db.Exec(cmd,
-		content,
-		t.ID,
-		priority,
-		"todo",
-		dueDate,
-		time.Now())
+		due_at,
+		project_id,
+		parent_id,
+		position,
+		rank,
+		recurrence,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ` + appendRank + `, ?, ?, ?)`
+	now := time.Now().UTC()
+	result, err := Db.Exec(cmd,
+		t.Content,
+		t.Description,
+		u.ID,
+		u.workspaceRef(),
+		t.Priority,
+		t.Status,
+		t.DueDate,
+		t.DueAt,
+		nullableID(t.ProjectID),
+		nullableID(t.ParentID),
+		position,
+		rankStep, u.ID,
+		t.Recurrence,
+		now,
+		now)
 	if err != nil {
 		log.Println("CreateTodo error:", err)
+		return todo, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return todo, err
+	}
+	if t.Recurrence != "" {
+		// A recurring todo starts its own series.
+		if _, err = Db.Exec(`UPDATE todos SET series_id = id WHERE id = ?`, id); err != nil {
+			log.Println("CreateTodo error:", err)
+			return todo, err
+		}
+	}
+	if todo, err = u.GetTodo(int(id)); err != nil {
+		return todo, err
+	}
+	err = u.recordTodoChange(HistoryCreated, nil, &todo)
+	return todo, err
+}
+
+// GetTodo returns one of the todos in the user's space. Todos owned by
+// someone else or in another workspace are reported as sql.ErrNoRows, the
+// same as todos that do not exist.
+func (u *User) GetTodo(id int) (todo Todo, err error) {
+	return getTodo(id, u.ID, u.workspaceID, u.location())
+}
+
+// todoColumns is the select list matching todoFields.
+const todoColumns = `todos.id, todos.content, COALESCE(todos.description, ''), todos.user_id, todos.workspace_id,
+		COALESCE(todos.priority, 'medium'),
+		COALESCE(todos.status, 'todo'),
+		COALESCE(todos.due_date, date('now')),
+		todos.due_at,
+		todos.project_id,
+		todos.parent_id,
+		todos.position,
+		todos.rank,
+		todos.recurrence,
+		todos.series_id,
+		todos.occurrence,
+		(SELECT COUNT(*) FROM comments WHERE comments.todo_id = todos.id),
+		todos.created_at,
+		todos.updated_at,
+		todos.completed_at,
+		todos.deleted_at`
+
+func todoFields(t *Todo) []interface{} {
+	return []interface{}{
+		&t.ID,
+		&t.Content,
+		&t.Description,
+		&t.UserID,
+		&t.WorkspaceID,
+		&t.Priority,
+		&t.Status,
+		&t.DueDate,
+		&t.DueAt,
+		&t.ProjectID,
+		&t.ParentID,
+		&t.Position,
+		&t.Rank,
+		&t.Recurrence,
+		&t.SeriesID,
+		&t.Occurrence,
+		&t.CommentCount,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.CompletedAt,
+		&t.DeletedAt,
 	}
-	return err
 }
 
-func GetTodo(id int) (todo Todo, err error) {
-	cmd := `SELECT id, content, user_id, 
-		COALESCE(priority, 'medium') as priority,
-		COALESCE(status, 'todo') as status,
-		COALESCE(due_date, date('now')) as due_date,
-		created_at FROM todos
-	WHERE id = ?`
+func getTodo(id, userID, workspaceID int, loc *time.Location) (todo Todo, err error) {
+	cmd := `SELECT ` + todoColumns + ` FROM todos
+	WHERE todos.id = ? AND todos.user_id = ? AND ` + inWorkspace("todos") + ` AND todos.deleted_at IS NULL`
 
 	todo = Todo{}
-	err = Db.QueryRow(cmd, id).Scan(
-		&todo.ID,
-		&todo.Content,
-		&todo.UserID,
-		&todo.Priority,
-		&todo.Status,
-		&todo.DueDate,
-		&todo.CreatedAt,
-	)
+	err = Db.QueryRow(cmd, id, userID, workspaceID).Scan(todoFields(&todo)...)
 	if err != nil {
-		log.Println("GetTodo error:", err)
+		if err != sql.ErrNoRows {
+			log.Println("GetTodo error:", err)
+		}
+		return todo, err
 	}
-	return todo, err
+	todos := []Todo{todo}
+	err = loadTodoDetails(todos, loc)
+	return todos[0], err
+}
+
+// loadTodoDetails fills in the tags and subtasks of each todo, which must
+// all belong to the same user, and the due fields computed for loc, the
+// time zone of the user reading them.
+func loadTodoDetails(todos []Todo, loc *time.Location) error {
+	if len(todos) == 0 {
+		return nil
+	}
+	if err := loadTodoTags(todos); err != nil {
+		return err
+	}
+	if err := annotateDone(todos); err != nil {
+		return err
+	}
+	if err := loadSubtasks(todos); err != nil {
+		return err
+	}
+	annotateDue(todos, loc)
+	return nil
 }
 
+// GetTodosByUser returns all of the todos in the user's space in manual
+// order.
 func (u *User) GetTodosByUser() (todos []Todo, err error) {
-	cmd := `SELECT id, content, user_id, 
-		COALESCE(priority, 'medium') as priority,
-		COALESCE(status, 'todo') as status,
-		COALESCE(due_date, date('now')) as due_date,
-		created_at FROM todos
-	WHERE user_id = ?`
-	rows, err := Db.Query(cmd, u.ID)
+	todos, _, err = u.ListTodos(TodoQuery{Sort: "rank"})
+	return todos, err
+}
+
+// UpdateTodo saves t if it belongs to t.UserID and is in the workspace
+// given by t.WorkspaceID, see ReplaceTodo.
+func (t *Todo) UpdateTodo() (todo Todo, err error) {
+	owner := User{ID: t.UserID, workspaceID: idOrZero(t.WorkspaceID)}
+	return owner.ReplaceTodo(*t)
+}
+
+// ReplaceTodo saves every field of t on one of the todos in the user's
+// space and returns the row as stored. The owner and workspace are never
+// changed; a todo outside the user's space is reported as sql.ErrNoRows. An
+// empty status means the first column of the workflow, and status changes
+// must be allowed by it. Completing a recurring todo creates its next
+// occurrence.
+func (u *User) ReplaceTodo(t Todo) (todo Todo, err error) {
+	before, err := u.GetTodo(t.ID)
 	if err != nil {
-		log.Println("GetTodosByUser error:", err)
-		return todos, err
-	}
-	defer rows.Close()
-	
-	for rows.Next() {
-		var todo Todo
-		err = rows.Scan(
-			&todo.ID,
-			&todo.Content,
-			&todo.UserID,
-			&todo.Priority,
-			&todo.Status,
-			&todo.DueDate,
-			&todo.CreatedAt,
-		)
-		if err != nil {
-			log.Println("Scan error:", err)
-			continue
+		return todo, err
+	}
+	loc := u.location()
+	if t.DueDate == "" {
+		t.DueDate = today(loc)
+	}
+	if t.Status, err = workflowStatus(u.ID, &before, idOrZero(t.ProjectID), &t.Status); err != nil {
+		return todo, err
+	}
+	t.Recurrence = normalizeRecurrence(t.Recurrence)
+	if err = t.Validate(); err != nil {
+		return todo, err
+	}
+	if t.DueAt, err = dueAt(t.DueDate, t.DueTime, loc); err != nil {
+		return todo, err
+	}
+	t.DueDate = u.storedDueDate(t.DueDate, t.DueAt)
+	if err = u.checkProjectRef(t.ProjectID); err != nil {
+		return todo, err
+	}
+	if err = u.checkParentRef(t.ParentID, t.ID); err != nil {
+		return todo, err
+	}
+	if err = u.checkWorkflow(&before, idOrZero(t.ProjectID), t.Status, idOrZero(t.ParentID) == 0); err != nil {
+		return todo, err
+	}
+	position, err := nextPosition(u.ID, t.ParentID)
+	if err != nil {
+		return todo, err
+	}
+	// The position only changes when the todo moves to another parent.
+	cmd := `UPDATE todos SET content = ?, description = ?, priority = ?, status = ?, due_date = ?, due_at = ?, project_id = ?,
+		position = CASE WHEN COALESCE(parent_id, 0) = COALESCE(?, 0) THEN position ELSE ? END,
+		parent_id = ?,
+		recurrence = ?, ` + seriesIDUpdate + `
+		WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+	parentID := nullableID(t.ParentID)
+	result, err := Db.Exec(cmd, t.Content, t.Description, t.Priority, t.Status, t.DueDate, t.DueAt, nullableID(t.ProjectID),
+		parentID, position, parentID, t.Recurrence, t.Recurrence, t.ID, u.ID)
+	if err != nil {
+		log.Println("UpdateTodo error:", err)
+		return todo, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return todo, sql.ErrNoRows
+	}
+	return u.afterTodoUpdate(HistoryUpdated, &before)
+}
+
+// seriesIDUpdate starts a series when a todo first gets a recurrence rule;
+// its argument is the new rule.
+const seriesIDUpdate = `series_id = CASE WHEN ? != '' THEN COALESCE(series_id, id) ELSE series_id END`
+
+// afterTodoUpdate re-reads a saved todo and creates the next occurrence
+// when it completed a recurring series entry.
+func (u *User) afterTodoUpdate(action HistoryAction, before *Todo) (todo Todo, err error) {
+	todo, err = u.GetTodo(before.ID)
+	if err != nil {
+		return todo, err
+	}
+	if err = u.recordTodoChange(action, before, &todo); err != nil {
+		return todo, err
+	}
+	if todo.DueDate != before.DueDate || todo.DueTime != before.DueTime {
+		if err = refreshFireAt(Db, `reminders.todo_id = ?`, todo.ID); err != nil {
+			return todo, err
 		}
-		todos = append(todos, todo)
 	}
-	return todos, err
+	err = u.createNextOccurrence(todo)
+	return todo, err
+}
+
+// TodoPatch holds the fields of a partial update. Nil fields are left as
+// they are.
+type TodoPatch struct {
+	Content     *string
+	Description *string
+	Priority    *Priority
+	Status      *Status // "" means the first column of the workflow
+	DueDate     *string // "" means today in the user's time zone
+	DueTime     *string // "" makes the todo all-day
+	ProjectID   *int    // 0 removes the todo from its project
+	ParentID    *int    // 0 turns a subtask into a top-level todo
+	Recurrence  *string
+}
+
+func (p TodoPatch) IsEmpty() bool {
+	return p.Content == nil && p.Description == nil && p.Priority == nil && p.Status == nil && p.DueDate == nil && p.DueTime == nil && p.ProjectID == nil && p.ParentID == nil && p.Recurrence == nil
 }
 
-func (t *Todo) UpdateTodo() error {
-	cmd := `UPDATE todos SET content = ?, user_id = ?, priority = ?, status = ?, due_date = ? WHERE id = ?`
-	_, err := Db.Exec(cmd, t.Content, t.UserID, t.Priority, t.Status, t.DueDate, t.ID)
+// PatchTodo updates only the columns set in p on one of the user's todos and
+// returns the row as stored. Like UpdateTodo it continues recurring series.
+func (u *User) PatchTodo(id int, p TodoPatch) (todo Todo, err error) {
+	return u.patchTodo(id, p, HistoryUpdated)
+}
+
+// patchTodo is PatchTodo recording the change in the history as action.
+func (u *User) patchTodo(id int, p TodoPatch, action HistoryAction) (todo Todo, err error) {
+	before, err := u.GetTodo(id)
 	if err != nil {
-		log.Println("UpdateTodo error:", err)
+		return todo, err
+	}
+	projectID := idOrZero(before.ProjectID)
+	if p.ProjectID != nil {
+		projectID = *p.ProjectID
+	}
+	if p.Status != nil || p.ProjectID != nil {
+		status, err := workflowStatus(u.ID, &before, projectID, p.Status)
+		if err != nil {
+			return todo, err
+		}
+		p.Status = &status
+	}
+	if p.Recurrence != nil {
+		rule := normalizeRecurrence(*p.Recurrence)
+		p.Recurrence = &rule
+	}
+	loc := u.location()
+	if p.DueDate != nil && *p.DueDate == "" {
+		day := today(loc)
+		p.DueDate = &day
+	}
+	if err = p.Validate(); err != nil {
+		return todo, err
+	}
+	if err = u.checkProjectRef(p.ProjectID); err != nil {
+		return todo, err
+	}
+	if err = u.checkParentRef(p.ParentID, id); err != nil {
+		return todo, err
+	}
+	// A subtask turned into a top-level todo joins its column's WIP count.
+	promoted := before.ParentID != nil && p.ParentID != nil && *p.ParentID == 0
+	if p.Status != nil || promoted {
+		status := before.Status
+		if p.Status != nil {
+			status = *p.Status
+		}
+		topLevel := before.ParentID == nil
+		if p.ParentID != nil {
+			topLevel = *p.ParentID == 0
+		}
+		if err = u.checkWorkflow(&before, projectID, status, topLevel); err != nil {
+			return todo, err
+		}
+	}
+	var sets []string
+	var args []interface{}
+	if p.Content != nil {
+		sets = append(sets, "content = ?")
+		args = append(args, *p.Content)
+	}
+	if p.Description != nil {
+		sets = append(sets, "description = ?")
+		args = append(args, *p.Description)
+	}
+	if p.Priority != nil {
+		sets = append(sets, "priority = ?")
+		args = append(args, *p.Priority)
+	}
+	if p.Status != nil {
+		sets = append(sets, "status = ?")
+		args = append(args, *p.Status)
+	}
+	if p.DueDate != nil || p.DueTime != nil {
+		// The due instant depends on both, so fill in whichever one the
+		// patch leaves alone.
+		dueDate, dueTime := before.DueDate, before.DueTime
+		if p.DueDate != nil {
+			dueDate = *p.DueDate
+		}
+		if p.DueTime != nil {
+			dueTime = *p.DueTime
+		}
+		at, err := dueAt(dueDate, dueTime, loc)
+		if err != nil {
+			return todo, err
+		}
+		sets = append(sets, "due_date = ?", "due_at = ?")
+		args = append(args, u.storedDueDate(dueDate, at), at)
+	}
+	if p.ProjectID != nil {
+		sets = append(sets, "project_id = ?")
+		args = append(args, nullableID(p.ProjectID))
+	}
+	if p.ParentID != nil {
+		position, err := nextPosition(u.ID, p.ParentID)
+		if err != nil {
+			return todo, err
+		}
+		parentID := nullableID(p.ParentID)
+		sets = append(sets, "position = CASE WHEN COALESCE(parent_id, 0) = COALESCE(?, 0) THEN position ELSE ? END", "parent_id = ?")
+		args = append(args, parentID, position, parentID)
+	}
+	if p.Recurrence != nil {
+		sets = append(sets, "recurrence = ?", seriesIDUpdate)
+		args = append(args, *p.Recurrence, *p.Recurrence)
+	}
+	if len(sets) == 0 {
+		return before, nil
 	}
-	return err
+
+	cmd := `UPDATE todos SET ` + strings.Join(sets, ", ") + ` WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+	args = append(args, id, u.ID)
+	result, err := Db.Exec(cmd, args...)
+	if err != nil {
+		log.Println("PatchTodo error:", err)
+		return todo, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return todo, sql.ErrNoRows
+	}
+	return u.afterTodoUpdate(action, &before)
+}
+
+// RenderDescription fills DescriptionHTML with the sanitised HTML rendering
+// of the Markdown description, for the todo and its subtasks.
+func (t *Todo) RenderDescription() {
+	t.DescriptionHTML = utils.RenderMarkdown(t.Description)
+	for i := range t.Subtasks {
+		t.Subtasks[i].RenderDescription()
+	}
+}
+
+// todoLinkTables hold rows that belong to a todo, keyed by todo_id, and are
+// removed when it is purged from the trash.
+var todoLinkTables = []string{"todo_tags", "reminders", "todo_history", "todo_status_changes", "attachments", "comments"}
+
+// DeleteTodo moves t to the trash if it belongs to t.UserID and is in the
+// workspace given by t.WorkspaceID, see TrashTodo.
+func (t *Todo) DeleteTodo(cascade bool) error {
+	owner := User{ID: t.UserID, workspaceID: idOrZero(t.WorkspaceID)}
+	return owner.TrashTodo(t.ID, cascade)
 }
 
-func (t *Todo) DeleteTodo() error {
-	cmd := `DELETE FROM todos WHERE id = ?`
-	_, err := Db.Exec(cmd, t.ID)
+// TrashTodo moves one of the todos in the user's space to the trash,
+// otherwise it returns sql.ErrNoRows. With cascade its subtasks go to the
+// trash with it; without it they become top-level todos. Linked rows are
+// kept so that RestoreTodo can bring the todo back as it was.
+func (u *User) TrashTodo(id int, cascade bool) error {
+	cmd := `SELECT id FROM todos WHERE parent_id = ? AND user_id = ? AND deleted_at IS NULL`
+	subtaskIDs, err := queryIDs(cmd, id, u.ID)
 	if err != nil {
 		log.Println("DeleteTodo error:", err)
+		return err
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	cmd = `UPDATE todos SET deleted_at = ? WHERE id = ? AND user_id = ? AND ` + inWorkspace("todos") + ` AND deleted_at IS NULL`
+	result, err := tx.Exec(cmd, now, id, u.ID, u.workspaceID)
+	if err != nil {
+		log.Println("DeleteTodo error:", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	// Subtasks that were trashed on their own earlier stay in the trash as
+	// top-level todos, so restoring the todo only brings back what went
+	// with it.
+	cmd = `UPDATE todos SET parent_id = NULL, position = 0 WHERE parent_id = ? AND user_id = ? AND deleted_at IS NOT NULL`
+	if _, err = tx.Exec(cmd, id, u.ID); err != nil {
+		log.Println("DeleteTodo error:", err)
+		return err
+	}
+	if cascade {
+		_, err = tx.Exec(`UPDATE todos SET deleted_at = ? WHERE parent_id = ? AND user_id = ?`, now, id, u.ID)
+	} else {
+		_, err = tx.Exec(`UPDATE todos SET parent_id = NULL, position = 0 WHERE parent_id = ? AND user_id = ?`, id, u.ID)
+	}
+	if err != nil {
+		log.Println("DeleteTodo error:", err)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if err = recordHistory(id, u.ID, u.actor(), HistoryDeleted, nil); err != nil {
+		return err
+	}
+	detached := []FieldChange{{Field: "parent_id", Old: id, New: nil}}
+	for _, subtaskID := range subtaskIDs {
+		if cascade {
+			err = recordHistory(subtaskID, u.ID, u.actor(), HistoryDeleted, nil)
+		} else {
+			err = recordHistory(subtaskID, u.ID, u.actor(), HistoryUpdated, detached)
+		}
+		if err != nil {
+			return err
+		}
 	}
-	return err
+	return nil
 }
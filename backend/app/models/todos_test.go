@@ -0,0 +1,66 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestTodoOwnership(t *testing.T) {
+	owner := newTestUser(t)
+	other := newTestUser(t)
+	todo := newTestTodo(t, owner, "private")
+	content := "taken over"
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"GetTodo", func() error {
+			_, err := other.GetTodo(todo.ID)
+			return err
+		}},
+		{"ReplaceTodo", func() error {
+			t := todo
+			t.Content = content
+			_, err := other.ReplaceTodo(t)
+			return err
+		}},
+		{"PatchTodo", func() error {
+			_, err := other.PatchTodo(todo.ID, TodoPatch{Content: &content})
+			return err
+		}},
+		{"TrashTodo", func() error {
+			return other.TrashTodo(todo.ID, false)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("err = %v, want sql.ErrNoRows", err)
+			}
+		})
+	}
+
+	got, err := owner.GetTodo(todo.ID)
+	if err != nil {
+		t.Fatalf("owner GetTodo: %v", err)
+	}
+	if got.Content != "private" || got.UserID != owner.ID || got.DeletedAt != nil {
+		t.Errorf("todo changed by another user: %+v", got)
+	}
+}
+
+func TestTodoOwnershipInList(t *testing.T) {
+	owner := newTestUser(t)
+	other := newTestUser(t)
+	newTestTodo(t, owner, "private")
+
+	todos, _, err := other.ListTodos(TodoQuery{})
+	if err != nil {
+		t.Fatalf("ListTodos: %v", err)
+	}
+	if len(todos) != 0 {
+		t.Errorf("other user sees %d todos, want 0", len(todos))
+	}
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// GetTrash returns the trashed todos of the user's space, most recently
+// deleted first.
+// Subtasks that went to the trash with their parent are nested under it
+// rather than listed on their own.
+func (u *User) GetTrash() (todos []Todo, err error) {
+	cmd := `SELECT ` + todoColumns + ` FROM todos
+	WHERE todos.user_id = ? AND ` + inWorkspace("todos") + ` AND todos.deleted_at IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM todos parent WHERE parent.id = todos.parent_id AND parent.deleted_at IS NOT NULL)
+	ORDER BY julianday(todos.deleted_at) DESC, todos.id DESC`
+	rows, err := Db.Query(cmd, u.ID, u.workspaceID)
+	if err != nil {
+		log.Println("GetTrash error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var todo Todo
+		if err = rows.Scan(todoFields(&todo)...); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		todos = append(todos, todo)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if err = loadTodoDetails(todos, u.location()); err != nil {
+		return nil, err
+	}
+	return todos, nil
+}
+
+// RestoreTodo takes a todo of the user's space out of the trash together with
+// the subtasks that were trashed with it. A subtask whose parent is gone or
+// no longer top-level is restored as a top-level todo.
+func (u *User) RestoreTodo(id int) (todo Todo, err error) {
+	cmd := `SELECT id FROM todos WHERE (id = ? OR parent_id = ?) AND user_id = ? AND deleted_at IS NOT NULL`
+	restoredIDs, err := queryIDs(cmd, id, id, u.ID)
+	if err != nil {
+		log.Println("RestoreTodo error:", err)
+		return todo, err
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return todo, err
+	}
+	defer tx.Rollback()
+
+	var parentID sql.NullInt64
+	cmd = `SELECT parent_id FROM todos WHERE id = ? AND user_id = ? AND ` + inWorkspace("todos") + ` AND deleted_at IS NOT NULL`
+	if err = tx.QueryRow(cmd, id, u.ID, u.workspaceID).Scan(&parentID); err != nil {
+		return todo, err
+	}
+	var changes []FieldChange
+	if parentID.Valid {
+		var live int
+		cmd = `SELECT COUNT(*) FROM todos WHERE id = ? AND user_id = ? AND deleted_at IS NULL AND parent_id IS NULL`
+		if err = tx.QueryRow(cmd, parentID.Int64, u.ID).Scan(&live); err != nil {
+			return todo, err
+		}
+		if live == 0 {
+			if _, err = tx.Exec(`UPDATE todos SET parent_id = NULL, position = 0 WHERE id = ?`, id); err != nil {
+				log.Println("RestoreTodo error:", err)
+				return todo, err
+			}
+			changes = []FieldChange{{Field: "parent_id", Old: int(parentID.Int64), New: nil}}
+		}
+	}
+	cmd = `UPDATE todos SET deleted_at = NULL WHERE (id = ? OR parent_id = ?) AND user_id = ? AND deleted_at IS NOT NULL`
+	if _, err = tx.Exec(cmd, id, id, u.ID); err != nil {
+		log.Println("RestoreTodo error:", err)
+		return todo, err
+	}
+	if err = tx.Commit(); err != nil {
+		return todo, err
+	}
+
+	for _, restored := range restoredIDs {
+		var restoredChanges []FieldChange
+		if restored == id {
+			restoredChanges = changes
+		}
+		if err = recordHistory(restored, u.ID, u.actor(), HistoryRestored, restoredChanges); err != nil {
+			return todo, err
+		}
+	}
+	return u.GetTodo(id)
+}
+
+// PurgeTodo permanently deletes a trashed todo of the user's space, the
+// subtasks trashed with it and everything linked to them. Live todos are
+// reported as sql.ErrNoRows; they have to be trashed first.
+func (u *User) PurgeTodo(id int) error {
+	var trashed int
+	cmd := `SELECT COUNT(*) FROM todos WHERE id = ? AND user_id = ? AND ` + inWorkspace("todos") + ` AND deleted_at IS NOT NULL`
+	if err := Db.QueryRow(cmd, id, u.ID, u.workspaceID).Scan(&trashed); err != nil {
+		log.Println("PurgeTodo error:", err)
+		return err
+	}
+	if trashed == 0 {
+		return sql.ErrNoRows
+	}
+	cmd = `SELECT id FROM todos WHERE (id = ? OR parent_id = ?) AND user_id = ? AND deleted_at IS NOT NULL`
+	ids, err := queryIDs(cmd, id, id, u.ID)
+	if err != nil {
+		log.Println("PurgeTodo error:", err)
+		return err
+	}
+	_, err = purgeTodos(ids)
+	return err
+}
+
+// EmptyTrash permanently deletes every trashed todo of the user's space.
+func (u *User) EmptyTrash() (purged int64, err error) {
+	cmd := `SELECT id FROM todos WHERE user_id = ? AND ` + inWorkspace("todos") + ` AND deleted_at IS NOT NULL`
+	ids, err := queryIDs(cmd, u.ID, u.workspaceID)
+	if err != nil {
+		log.Println("EmptyTrash error:", err)
+		return 0, err
+	}
+	return purgeTodos(ids)
+}
+
+// PurgeExpiredTodos permanently deletes every todo that has been in the
+// trash for longer than retention. Subtasks trashed with their parent share
+// its deletion time, so they always go together.
+func PurgeExpiredTodos(retention time.Duration) (purged int64, err error) {
+	cmd := `SELECT id FROM todos WHERE deleted_at IS NOT NULL AND julianday(deleted_at) <= julianday(?)`
+	ids, err := queryIDs(cmd, time.Now().UTC().Add(-retention))
+	if err != nil {
+		log.Println("PurgeExpiredTodos error:", err)
+		return 0, err
+	}
+	return purgeTodos(ids)
+}
+
+// StartTrashPurger purges expired trash every interval until the process
+// exits. Like the reminder scheduler it runs once right away, catching up on
+// whatever expired while the server was down.
+func StartTrashPurger(interval, retention time.Duration) {
+	go func() {
+		run := func() {
+			n, err := PurgeExpiredTodos(retention)
+			if err == nil && n > 0 {
+				log.Printf("Trash purger removed %d todos", n)
+			}
+		}
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run()
+		}
+	}()
+}
+
+// purgeTodos deletes the todos with the given ids and their rows in
+// todoLinkTables in one transaction, then the attachment contents nothing
+// else refers to.
+func purgeTodos(ids []int) (purged int64, err error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	in := placeholders(len(args))
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var blobKeys []string
+	rows, err := tx.Query(`SELECT DISTINCT blob_key FROM attachments WHERE todo_id IN (`+in+`)`, args...)
+	if err != nil {
+		log.Println("purgeTodos error:", err)
+		return 0, err
+	}
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		blobKeys = append(blobKeys, key)
+	}
+	rows.Close()
+
+	for _, table := range todoLinkTables {
+		if _, err = tx.Exec(`DELETE FROM `+table+` WHERE todo_id IN (`+in+`)`, args...); err != nil {
+			log.Println("purgeTodos error:", err)
+			return 0, err
+		}
+	}
+	result, err := tx.Exec(`DELETE FROM todos WHERE id IN (`+in+`)`, args...)
+	if err != nil {
+		log.Println("purgeTodos error:", err)
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	// The todos are gone either way; a blob left behind only costs space.
+	if err = deleteOrphanBlobs(blobKeys); err != nil {
+		log.Println("purgeTodos error:", err)
+	}
+	return result.RowsAffected()
+}
+
+func queryIDs(query string, args ...interface{}) (ids []int, err error) {
+	rows, err := Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -1,42 +1,78 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
 	"log"
 	"time"
+
+	"todo_app/config"
 )
 
+var ErrSessionExpired = errors.New("session expired")
+
+// sessionTouchInterval limits how often last_seen_at is written for a
+// session that is being used continuously.
+const sessionTouchInterval = time.Minute
+
 type User struct {
 	ID        int       `json:"id"`
 	UUID      string    `json:"uuid"`
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
 	Password  string    `json:"password"`
+	TimeZone  string    `json:"time_zone"`
 	CreatedAt time.Time `json:"created_at"`
 	Todos     []Todo    `json:"todos"`
+
+	// A User obtained from one of the Scope methods acts on a workspace:
+	// ID is then the workspace owner, under whose id its rows are stored,
+	// and actorID the member making the request. Both are zero for the
+	// user's personal space.
+	workspaceID int
+	actorID     int
 }
 
 type Session struct {
-	ID        int       `json:"id"`
-	UUID      string    `json:"uuid"`
-	Email     string    `json:"email"`
-	UserID    int       `json:"user_id"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         int       `json:"id"`
+	UUID       string    `json:"uuid"`
+	Email      string    `json:"email"`
+	UserID     int       `json:"user_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
 }
 
 func (u *User) CreateUser() (err error) {
+	if u.TimeZone == "" {
+		u.TimeZone = DefaultTimeZone
+	}
+	v := &ValidationError{}
+	validateTimeZone(v, u.TimeZone)
+	if err = v.Err(); err != nil {
+		return err
+	}
+	hashed, err := HashPassword(u.Password)
+	if err != nil {
+		log.Println("HashPassword error:", err)
+		return err
+	}
 	cmd := `INSERT INTO users (
 		uuid,
 		name,
 		email,
 		password,
+		time_zone,
 		created_at
-	) VALUES (?, ?, ?, ?, ?)`
+	) VALUES (?, ?, ?, ?, ?, ?)`
 	_, err = Db.Exec(cmd,
 		createUUID(),
 		u.Name,
 		u.Email,
-		Encrypt(u.Password),
-		time.Now())
+		hashed,
+		u.TimeZone,
+		time.Now().UTC())
 	if err != nil {
 		log.Println("CreateUser error:", err)
 	}
@@ -51,6 +87,7 @@ func GetUser(id int) (user User, err error) {
 		name,
 		email,
 		password,
+		time_zone,
 		created_at
 	FROM users WHERE id = ?`
 	err = Db.QueryRow(cmd, id).Scan(
@@ -59,6 +96,7 @@ func GetUser(id int) (user User, err error) {
 		&user.Name,
 		&user.Email,
 		&user.Password,
+		&user.TimeZone,
 		&user.CreatedAt,
 	)
 	if err != nil {
@@ -76,6 +114,24 @@ func (u *User) UpdateUser() (err error) {
 	return err
 }
 
+// UpdatePassword stores a fresh hash of password using the current
+// DefaultPasswordHasher.
+func (u *User) UpdatePassword(password string) (err error) {
+	hashed, err := HashPassword(password)
+	if err != nil {
+		log.Println("HashPassword error:", err)
+		return err
+	}
+	cmd := `UPDATE users SET password = ? WHERE id = ?`
+	_, err = Db.Exec(cmd, hashed, u.ID)
+	if err != nil {
+		log.Println("UpdatePassword error:", err)
+		return err
+	}
+	u.Password = hashed
+	return nil
+}
+
 func (u *User) DeleteUser() (err error) {
 	cmd := `Delete from users where id = ?`
 	_, err = Db.Exec(cmd, u.ID)
@@ -93,6 +149,7 @@ func GetUserByEmail(email string) (user User, err error) {
 		name,
 		email,
 		password,
+		time_zone,
 		created_at
 	FROM users WHERE email = ?`
 	err = Db.QueryRow(cmd, email).Scan(
@@ -101,6 +158,7 @@ func GetUserByEmail(email string) (user User, err error) {
 		&user.Name,
 		&user.Email,
 		&user.Password,
+		&user.TimeZone,
 		&user.CreatedAt,
 	)
 	if err != nil {
@@ -109,46 +167,145 @@ func GetUserByEmail(email string) (user User, err error) {
 	return user, err
 }
 
-func (u *User) CreateSession() (s Session, err error) {
-	session := Session{}
-	cmd1 := `INSERT INTO sessions (
+// CreateSession starts a new session for the user. Each login gets its own
+// row, so a user can be signed in on several devices at once.
+func (u *User) CreateSession(userAgent, ip string) (s Session, err error) {
+	now := time.Now().UTC()
+	cmd := `INSERT INTO sessions (
 		uuid,
 		email,
 		user_id,
-		created_at) VALUES (?, ?, ?, ?)`
-	_, err = Db.Exec(cmd1, createUUID(), u.Email, u.ID, time.Now())
-	if err != nil {
-		log.Println(err)
-	}
-	cmd2 := `SELECT id, uuid, email, user_id, created_at FROM sessions WHERE user_id = ? and email = ?`
-	err = Db.QueryRow(cmd2, u.ID, u.Email).Scan(
-		&session.ID,
-		&session.UUID,
-		&session.Email,
-		&session.UserID,
-		&session.CreatedAt,
-	)
-	return session, err
+		user_agent,
+		ip,
+		created_at,
+		last_seen_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := Db.Exec(cmd, createUUID(), u.Email, u.ID, userAgent, ip, now, now)
+	if err != nil {
+		log.Println("CreateSession error:", err)
+		return s, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return s, err
+	}
+	return getSession(int(id))
 }
 
-func (s *Session) CheckSession() (valid bool, err error) {
-	cmd := `SELECT id, uuid, email, user_id, created_at
-	FROM sessions WHERE uuid = ?`
-	err = Db.QueryRow(cmd, s.UUID).Scan(
+const sessionColumns = `id, uuid, email, user_id, COALESCE(user_agent, ''), COALESCE(ip, ''), created_at, last_seen_at`
+
+func scanSession(row interface{ Scan(...interface{}) error }, s *Session) error {
+	return row.Scan(
 		&s.ID,
 		&s.UUID,
 		&s.Email,
 		&s.UserID,
+		&s.UserAgent,
+		&s.IP,
 		&s.CreatedAt,
+		&s.LastSeenAt,
 	)
+}
+
+func getSession(id int) (s Session, err error) {
+	cmd := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = ?`
+	err = scanSession(Db.QueryRow(cmd, id), &s)
+	return s, err
+}
+
+// GetSessions returns the user's unexpired sessions, most recently used first.
+func (u *User) GetSessions() (sessions []Session, err error) {
+	cmd := `SELECT ` + sessionColumns + ` FROM sessions
+	WHERE user_id = ? ORDER BY last_seen_at DESC`
+	rows, err := Db.Query(cmd, u.ID)
+	if err != nil {
+		log.Println("GetSessions error:", err)
+		return sessions, err
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	for rows.Next() {
+		var s Session
+		if err = scanSession(rows, &s); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		if now.Before(s.ExpiresAt()) {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteSession revokes one of the user's sessions. It returns
+// sql.ErrNoRows when the session does not belong to the user.
+func (u *User) DeleteSession(id int) (err error) {
+	cmd := `DELETE FROM sessions WHERE id = ? AND user_id = ?`
+	result, err := Db.Exec(cmd, id, u.ID)
+	if err != nil {
+		log.Println("DeleteSession error:", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteOtherSessions revokes every session of the user except keepUUID.
+func (u *User) DeleteOtherSessions(keepUUID string) (deleted int64, err error) {
+	cmd := `DELETE FROM sessions WHERE user_id = ? AND uuid != ?`
+	result, err := Db.Exec(cmd, u.ID, keepUUID)
+	if err != nil {
+		log.Println("DeleteOtherSessions error:", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ExpiresAt is the earlier of the absolute lifetime and the idle timeout.
+func (s *Session) ExpiresAt() time.Time {
+	absolute := s.CreatedAt.Add(config.Config.SessionLifetime)
+	idle := s.LastSeenAt.Add(config.Config.SessionIdleTimeout)
+	if idle.Before(absolute) {
+		return idle
+	}
+	return absolute
+}
+
+// CheckSession loads the session by UUID and reports whether it is still
+// valid. Expired sessions are deleted and reported with ErrSessionExpired;
+// valid ones have their last_seen_at refreshed.
+func (s *Session) CheckSession() (valid bool, err error) {
+	cmd := `SELECT ` + sessionColumns + ` FROM sessions WHERE uuid = ?`
+	err = scanSession(Db.QueryRow(cmd, s.UUID), s)
 	if err != nil {
 		valid = false
 		return valid, err
 	}
-	if s.ID != 0 {
-		valid = true
+	if s.ID == 0 {
+		return false, err
+	}
+	now := time.Now().UTC()
+	if !now.Before(s.ExpiresAt()) {
+		s.DeleteSessionByUUID()
+		return false, ErrSessionExpired
 	}
-	return valid, err
+	if now.Sub(s.LastSeenAt) >= sessionTouchInterval {
+		if err = s.touch(now); err != nil {
+			log.Println("Session touch error:", err)
+		}
+	}
+	return true, nil
+}
+
+func (s *Session) touch(now time.Time) (err error) {
+	cmd := `UPDATE sessions SET last_seen_at = ? WHERE id = ?`
+	_, err = Db.Exec(cmd, now, s.ID)
+	if err == nil {
+		s.LastSeenAt = now
+	}
+	return err
 }
 
 func (s *Session) DeleteSessionByUUID() (err error) {
@@ -168,6 +325,7 @@ func (s *Session) GetUserBySession() (user User, err error) {
 		name,
 		email,
 		password,
+		time_zone,
 		created_at
 	FROM users WHERE id = ?`
 	err = Db.QueryRow(cmd, s.UserID).Scan(
@@ -176,6 +334,7 @@ func (s *Session) GetUserBySession() (user User, err error) {
 		&user.Name,
 		&user.Email,
 		&user.Password,
+		&user.TimeZone,
 		&user.CreatedAt,
 	)
 	if err != nil {
@@ -183,3 +342,35 @@ func (s *Session) GetUserBySession() (user User, err error) {
 	}
 	return user, err
 }
+
+// DeleteExpiredSessions removes every session past its absolute lifetime or
+// idle timeout.
+func DeleteExpiredSessions() (deleted int64, err error) {
+	now := time.Now().UTC()
+	cmd := `DELETE FROM sessions
+	WHERE julianday(created_at) <= julianday(?)
+		OR julianday(COALESCE(last_seen_at, created_at)) <= julianday(?)`
+	result, err := Db.Exec(cmd,
+		now.Add(-config.Config.SessionLifetime),
+		now.Add(-config.Config.SessionIdleTimeout))
+	if err != nil {
+		log.Println("DeleteExpiredSessions error:", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// StartSessionSweeper purges expired sessions every interval until the
+// process exits.
+func StartSessionSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			n, err := DeleteExpiredSessions()
+			if err == nil && n > 0 {
+				log.Printf("Session sweeper removed %d expired sessions", n)
+			}
+		}
+	}()
+}
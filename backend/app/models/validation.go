@@ -0,0 +1,165 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityMedium Priority = "medium"
+	PriorityLow    Priority = "low"
+)
+
+func (p Priority) Valid() bool {
+	switch p {
+	case PriorityHigh, PriorityMedium, PriorityLow:
+		return true
+	}
+	return false
+}
+
+// Status is the key of a workflow column. The constants are the columns of
+// the default workflow; projects can define their own.
+type Status string
+
+const (
+	StatusTodo       Status = "todo"
+	StatusInProgress Status = "in_progress"
+	StatusCompleted  Status = "completed"
+)
+
+// Valid only checks the form of the key. Whether a todo can be in it
+// depends on its project's workflow, see checkWorkflow.
+func (s Status) Valid() bool {
+	return statusKeyPattern.MatchString(string(s))
+}
+
+const (
+	MaxTodoContentLength     = 500
+	MaxTodoDescriptionLength = 10000
+	DueDateLayout            = "2006-01-02"
+)
+
+// ValidationError collects per-field problems. Controllers turn it into a
+// 422 response listing Fields.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %s", k, e.Fields[k]))
+	}
+	return "validation failed: " + strings.Join(parts, ", ")
+}
+
+func (e *ValidationError) Add(field, message string) {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	if _, exists := e.Fields[field]; !exists {
+		e.Fields[field] = message
+	}
+}
+
+// Err returns nil when no field failed, so callers can `return v.Err()`.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func validateContent(v *ValidationError, content string) {
+	if strings.TrimSpace(content) == "" {
+		v.Add("content", "must not be empty")
+	} else if utf8.RuneCountInString(content) > MaxTodoContentLength {
+		v.Add("content", fmt.Sprintf("must be at most %d characters", MaxTodoContentLength))
+	}
+}
+
+func validateDescription(v *ValidationError, description string) {
+	if utf8.RuneCountInString(description) > MaxTodoDescriptionLength {
+		v.Add("description", fmt.Sprintf("must be at most %d characters", MaxTodoDescriptionLength))
+	}
+}
+
+func validatePriority(v *ValidationError, p Priority) {
+	if !p.Valid() {
+		v.Add("priority", `must be one of "high", "medium", "low"`)
+	}
+}
+
+func validateStatus(v *ValidationError, s Status) {
+	if !s.Valid() {
+		v.Add("status", "must be a workflow column key")
+	}
+}
+
+func validateDueDate(v *ValidationError, dueDate string) {
+	if _, err := time.Parse(DueDateLayout, dueDate); err != nil {
+		v.Add("dueDate", "must be a date in YYYY-MM-DD format")
+	}
+}
+
+// validateDueTime accepts "" for all-day todos.
+func validateDueTime(v *ValidationError, dueTime string) {
+	if dueTime == "" {
+		return
+	}
+	if _, err := time.Parse(DueTimeLayout, dueTime); err != nil {
+		v.Add("dueTime", "must be a time in HH:MM format")
+	}
+}
+
+// Validate checks a complete todo before it is inserted or replaced.
+func (t *Todo) Validate() error {
+	v := &ValidationError{}
+	validateContent(v, t.Content)
+	validateDescription(v, t.Description)
+	validatePriority(v, t.Priority)
+	validateStatus(v, t.Status)
+	validateDueDate(v, t.DueDate)
+	validateDueTime(v, t.DueTime)
+	validateRecurrence(v, t.Recurrence)
+	return v.Err()
+}
+
+// Validate checks only the fields the patch sets.
+func (p TodoPatch) Validate() error {
+	v := &ValidationError{}
+	if p.Content != nil {
+		validateContent(v, *p.Content)
+	}
+	if p.Description != nil {
+		validateDescription(v, *p.Description)
+	}
+	if p.Priority != nil {
+		validatePriority(v, *p.Priority)
+	}
+	if p.Status != nil {
+		validateStatus(v, *p.Status)
+	}
+	if p.DueDate != nil {
+		validateDueDate(v, *p.DueDate)
+	}
+	if p.DueTime != nil {
+		validateDueTime(v, *p.DueTime)
+	}
+	if p.Recurrence != nil {
+		validateRecurrence(v, *p.Recurrence)
+	}
+	return v.Err()
+}
@@ -0,0 +1,454 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxWorkflowColumns       = 20
+	MaxWorkflowColumnNameLen = 50
+)
+
+var statusKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,29}$`)
+
+// WorkflowColumn is one status a todo can be in. Todos in a Done column
+// count as finished: they are never overdue, their reminders wait, and
+// completing a recurring todo means moving it into one.
+type WorkflowColumn struct {
+	Key      Status `json:"key"`
+	Name     string `json:"name"`
+	Done     bool   `json:"done"`
+	WIPLimit int    `json:"wip_limit"` // 0 for no limit
+}
+
+// WorkflowTransition allows moving a todo from one column to another.
+type WorkflowTransition struct {
+	From Status `json:"from"`
+	To   Status `json:"to"`
+}
+
+// Workflow is the ordered set of columns of a project's board. New todos
+// start in the first column. Without Transitions any move is allowed.
+type Workflow struct {
+	ProjectID   int                  `json:"project_id"` // 0 for the default workflow
+	Columns     []WorkflowColumn     `json:"columns"`
+	Transitions []WorkflowTransition `json:"transitions"`
+}
+
+// DefaultWorkflow applies to todos without a project and to projects that
+// have not defined their own.
+func DefaultWorkflow() Workflow {
+	return Workflow{
+		Columns: []WorkflowColumn{
+			{Key: StatusTodo, Name: "To Do"},
+			{Key: StatusInProgress, Name: "In Progress"},
+			{Key: StatusCompleted, Name: "Done", Done: true},
+		},
+		Transitions: []WorkflowTransition{},
+	}
+}
+
+// Column returns the column with the given key, or nil.
+func (wf *Workflow) Column(key Status) *WorkflowColumn {
+	for i := range wf.Columns {
+		if wf.Columns[i].Key == key {
+			return &wf.Columns[i]
+		}
+	}
+	return nil
+}
+
+// Initial is the column new todos start in.
+func (wf *Workflow) Initial() Status {
+	return wf.Columns[0].Key
+}
+
+// DoneStatus is the first Done column, where completing a todo puts it.
+func (wf *Workflow) DoneStatus() Status {
+	for _, c := range wf.Columns {
+		if c.Done {
+			return c.Key
+		}
+	}
+	return wf.Initial()
+}
+
+func (wf *Workflow) Allows(from, to Status) bool {
+	if from == to || len(wf.Transitions) == 0 {
+		return true
+	}
+	for _, t := range wf.Transitions {
+		if t.From == from && t.To == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (wf *Workflow) keys() string {
+	keys := make([]string, len(wf.Columns))
+	for i, c := range wf.Columns {
+		keys[i] = `"` + string(c.Key) + `"`
+	}
+	return strings.Join(keys, ", ")
+}
+
+func (wf *Workflow) Validate() error {
+	v := &ValidationError{}
+	if len(wf.Columns) == 0 || len(wf.Columns) > MaxWorkflowColumns {
+		v.Add("columns", fmt.Sprintf("must have between 1 and %d columns", MaxWorkflowColumns))
+	}
+	seen := make(map[Status]bool)
+	done := false
+	for _, c := range wf.Columns {
+		switch {
+		case !statusKeyPattern.MatchString(string(c.Key)):
+			v.Add("columns", fmt.Sprintf("key %q must be lowercase letters, digits and underscores", c.Key))
+		case seen[c.Key]:
+			v.Add("columns", fmt.Sprintf("key %q is used twice", c.Key))
+		case strings.TrimSpace(c.Name) == "" || utf8.RuneCountInString(c.Name) > MaxWorkflowColumnNameLen:
+			v.Add("columns", fmt.Sprintf("name of %q must be 1 to %d characters", c.Key, MaxWorkflowColumnNameLen))
+		case c.WIPLimit < 0:
+			v.Add("columns", fmt.Sprintf("wipLimit of %q must not be negative", c.Key))
+		}
+		seen[c.Key] = true
+		done = done || c.Done
+	}
+	if len(wf.Columns) > 0 && !done {
+		v.Add("columns", "at least one column must be done")
+	}
+	if len(wf.Columns) > 0 && wf.Columns[0].Done {
+		v.Add("columns", "the first column, where new todos start, cannot be done")
+	}
+	listed := make(map[WorkflowTransition]bool)
+	for _, t := range wf.Transitions {
+		switch {
+		case !seen[t.From] || !seen[t.To] || t.From == t.To:
+			v.Add("transitions", fmt.Sprintf("%q to %q does not connect two different columns", t.From, t.To))
+		case listed[t]:
+			v.Add("transitions", fmt.Sprintf("%q to %q is listed twice", t.From, t.To))
+		}
+		listed[t] = true
+	}
+	return v.Err()
+}
+
+// workflowFor returns the workflow of one of userID's projects, or the
+// default one for projectID 0 and projects without their own.
+func workflowFor(userID, projectID int) (wf Workflow, err error) {
+	if projectID == 0 {
+		return DefaultWorkflow(), nil
+	}
+	cmd := `SELECT workflow_columns.key, workflow_columns.name, workflow_columns.done, workflow_columns.wip_limit
+	FROM workflow_columns JOIN projects ON projects.id = workflow_columns.project_id
+	WHERE workflow_columns.project_id = ? AND projects.user_id = ?
+	ORDER BY workflow_columns.position`
+	rows, err := Db.Query(cmd, projectID, userID)
+	if err != nil {
+		log.Println("workflowFor error:", err)
+		return wf, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c WorkflowColumn
+		if err = rows.Scan(&c.Key, &c.Name, &c.Done, &c.WIPLimit); err != nil {
+			return wf, err
+		}
+		wf.Columns = append(wf.Columns, c)
+	}
+	if err = rows.Err(); err != nil {
+		return wf, err
+	}
+	if len(wf.Columns) == 0 {
+		wf = DefaultWorkflow()
+		wf.ProjectID = projectID
+		return wf, nil
+	}
+	wf.ProjectID = projectID
+
+	wf.Transitions = []WorkflowTransition{}
+	cmd = `SELECT from_status, to_status FROM workflow_transitions WHERE project_id = ? ORDER BY id`
+	rows, err = Db.Query(cmd, projectID)
+	if err != nil {
+		log.Println("workflowFor error:", err)
+		return wf, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t WorkflowTransition
+		if err = rows.Scan(&t.From, &t.To); err != nil {
+			return wf, err
+		}
+		wf.Transitions = append(wf.Transitions, t)
+	}
+	return wf, rows.Err()
+}
+
+// GetWorkflow returns the workflow of one of the user's projects, or the
+// default workflow for projectID 0.
+func (u *User) GetWorkflow(projectID int) (wf Workflow, err error) {
+	if projectID != 0 {
+		if _, err = u.GetProject(projectID); err != nil {
+			return wf, err
+		}
+	}
+	return workflowFor(u.ID, projectID)
+}
+
+// SetWorkflow replaces the workflow of one of the user's projects. Columns
+// that still hold todos cannot be dropped; move the todos first.
+func (u *User) SetWorkflow(projectID int, wf Workflow) (saved Workflow, err error) {
+	if _, err = u.GetProject(projectID); err != nil {
+		return saved, err
+	}
+	for i := range wf.Columns {
+		wf.Columns[i].Name = strings.TrimSpace(wf.Columns[i].Name)
+	}
+	if err = wf.Validate(); err != nil {
+		return saved, err
+	}
+	if err = u.checkColumnsInUse(projectID, &wf); err != nil {
+		return saved, err
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return saved, err
+	}
+	defer tx.Rollback()
+
+	if err = deleteWorkflow(tx, projectID); err != nil {
+		log.Println("SetWorkflow error:", err)
+		return saved, err
+	}
+	for i, c := range wf.Columns {
+		cmd := `INSERT INTO workflow_columns (project_id, key, name, position, done, wip_limit) VALUES (?, ?, ?, ?, ?, ?)`
+		if _, err = tx.Exec(cmd, projectID, c.Key, c.Name, i, c.Done, c.WIPLimit); err != nil {
+			log.Println("SetWorkflow error:", err)
+			return saved, err
+		}
+	}
+	for _, t := range wf.Transitions {
+		cmd := `INSERT INTO workflow_transitions (project_id, from_status, to_status) VALUES (?, ?, ?)`
+		if _, err = tx.Exec(cmd, projectID, t.From, t.To); err != nil {
+			log.Println("SetWorkflow error:", err)
+			return saved, err
+		}
+	}
+	if err = updateCompletedAt(tx, u.ID, projectID); err != nil {
+		log.Println("SetWorkflow error:", err)
+		return saved, err
+	}
+	if err = tx.Commit(); err != nil {
+		return saved, err
+	}
+	return workflowFor(u.ID, projectID)
+}
+
+// ResetWorkflow puts one of the user's projects back on the default
+// workflow, under the same rule as SetWorkflow.
+func (u *User) ResetWorkflow(projectID int) (wf Workflow, err error) {
+	if _, err = u.GetProject(projectID); err != nil {
+		return wf, err
+	}
+	wf = DefaultWorkflow()
+	if err = u.checkColumnsInUse(projectID, &wf); err != nil {
+		return wf, err
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return wf, err
+	}
+	defer tx.Rollback()
+	if err = deleteWorkflow(tx, projectID); err != nil {
+		log.Println("ResetWorkflow error:", err)
+		return wf, err
+	}
+	if err = updateCompletedAt(tx, u.ID, projectID); err != nil {
+		log.Println("ResetWorkflow error:", err)
+		return wf, err
+	}
+	if err = tx.Commit(); err != nil {
+		return wf, err
+	}
+	return workflowFor(u.ID, projectID)
+}
+
+func deleteWorkflow(tx *sql.Tx, projectID int) error {
+	if _, err := tx.Exec(`DELETE FROM workflow_columns WHERE project_id = ?`, projectID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM workflow_transitions WHERE project_id = ?`, projectID)
+	return err
+}
+
+// updateCompletedAt brings completed_at of a project's todos in line with
+// a changed workflow, where a column may have started or stopped counting
+// as done.
+func updateCompletedAt(tx *sql.Tx, userID, projectID int) error {
+	cmd := `UPDATE todos SET ` + completedAtUpdate + ` WHERE project_id = ? AND user_id = ?`
+	_, err := tx.Exec(cmd, time.Now().UTC(), projectID, userID)
+	return err
+}
+
+// checkColumnsInUse reports the statuses of the project's todos, trashed
+// ones included, that wf has no column for.
+func (u *User) checkColumnsInUse(projectID int, wf *Workflow) error {
+	cmd := `SELECT COALESCE(status, 'todo'), COUNT(*) FROM todos WHERE project_id = ? AND user_id = ? GROUP BY 1 ORDER BY 1`
+	rows, err := Db.Query(cmd, projectID, u.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	v := &ValidationError{}
+	for rows.Next() {
+		var status Status
+		var count int
+		if err = rows.Scan(&status, &count); err != nil {
+			return err
+		}
+		if wf.Column(status) == nil {
+			v.Add("columns", fmt.Sprintf("%d todos are still in %q; move them before removing the column", count, status))
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return v.Err()
+}
+
+// workflowStatus resolves the status a todo is saved with in projectID's
+// workflow. "" means the first column. A nil status keeps the current one,
+// or when the todo moves to a project without that column, puts it in the
+// new workflow's first column, or its first done column if it was done.
+// before is nil for new todos.
+func workflowStatus(userID int, before *Todo, projectID int, status *Status) (Status, error) {
+	if status != nil && *status != "" {
+		return *status, nil
+	}
+	wf, err := workflowFor(userID, projectID)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case status != nil || before == nil:
+		return wf.Initial(), nil
+	case wf.Column(before.Status) != nil:
+		return before.Status, nil
+	case before.Done:
+		return wf.DoneStatus(), nil
+	}
+	return wf.Initial(), nil
+}
+
+// checkWorkflow validates putting a todo into status in projectID's
+// workflow. before is the todo as stored, or nil for a new one, and
+// topLevel tells whether it is saved without a parent. The move must be an
+// allowed transition within the same project, and a column at its WIP limit
+// accepts no more top-level todos, new ones and promoted subtasks included.
+func (u *User) checkWorkflow(before *Todo, projectID int, status Status, topLevel bool) error {
+	wf, err := workflowFor(u.ID, projectID)
+	if err != nil {
+		return err
+	}
+	invalid := func(message string) error {
+		return &ValidationError{Fields: map[string]string{"status": message}}
+	}
+	column := wf.Column(status)
+	if column == nil {
+		return invalid("must be one of " + wf.keys())
+	}
+	moved := before == nil || before.Status != status || idOrZero(before.ProjectID) != projectID || before.ParentID != nil
+	if before != nil && idOrZero(before.ProjectID) == projectID && !wf.Allows(before.Status, status) {
+		return invalid(fmt.Sprintf("cannot move from %q to %q", before.Status, status))
+	}
+	if column.WIPLimit > 0 && moved && topLevel {
+		excludeID := 0
+		if before != nil {
+			excludeID = before.ID
+		}
+		var count int
+		cmd := `SELECT COUNT(*) FROM todos WHERE ` + rankScope + ` AND id != ?`
+		if err = Db.QueryRow(cmd, u.ID, u.workspaceID, status, nullableID(&projectID), excludeID).Scan(&count); err != nil {
+			return err
+		}
+		if count >= column.WIPLimit {
+			return invalid(fmt.Sprintf("%q is at its WIP limit of %d", column.Name, column.WIPLimit))
+		}
+	}
+	return nil
+}
+
+// annotateDone sets Done on each todo, which must all belong to the same
+// user, from the workflow of its project.
+func annotateDone(todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+	workflows := make(map[int]*Workflow)
+	for i := range todos {
+		projectID := idOrZero(todos[i].ProjectID)
+		wf, ok := workflows[projectID]
+		if !ok {
+			loaded, err := workflowFor(todos[i].UserID, projectID)
+			if err != nil {
+				return err
+			}
+			wf = &loaded
+			workflows[projectID] = wf
+		}
+		column := wf.Column(todos[i].Status)
+		todos[i].Done = column != nil && column.Done
+	}
+	return nil
+}
+
+// todoDoneSQL is true for todos in a Done column of their workflow.
+var todoDoneSQL = `(CASE WHEN EXISTS (SELECT 1 FROM workflow_columns wc WHERE wc.project_id = todos.project_id)
+	THEN EXISTS (SELECT 1 FROM workflow_columns wc WHERE wc.project_id = todos.project_id AND wc.key = todos.status AND wc.done)
+	ELSE COALESCE(todos.status, 'todo') = '` + string(StatusCompleted) + `' END)`
+
+// initialStatusSQL is the first column of the workflow of todos.project_id.
+var initialStatusSQL = `COALESCE((SELECT wc.key FROM workflow_columns wc WHERE wc.project_id = todos.project_id
+	ORDER BY wc.position LIMIT 1), '` + string(StatusTodo) + `')`
+
+// BoardColumn is a workflow column with the todos in it, in manual order.
+type BoardColumn struct {
+	WorkflowColumn
+	Count int    `json:"count"`
+	Todos []Todo `json:"todos"`
+}
+
+// GetBoard groups the user's live top-level todos of a project, or those
+// without a project for projectID 0, by workflow column.
+func (u *User) GetBoard(projectID int) (wf Workflow, board []BoardColumn, err error) {
+	if wf, err = u.GetWorkflow(projectID); err != nil {
+		return wf, nil, err
+	}
+	q := TodoQuery{ProjectID: &projectID, Sort: "rank"}
+	todos, _, err := u.ListTodos(q)
+	if err != nil {
+		return wf, nil, err
+	}
+	board = make([]BoardColumn, len(wf.Columns))
+	index := make(map[Status]int, len(wf.Columns))
+	for i, c := range wf.Columns {
+		board[i] = BoardColumn{WorkflowColumn: c, Todos: []Todo{}}
+		index[c.Key] = i
+	}
+	for _, t := range todos {
+		if i, ok := index[t.Status]; ok {
+			board[i].Todos = append(board[i].Todos, t)
+			board[i].Count++
+		}
+	}
+	return wf, board, nil
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWIPLimitAppliesToNewTodos(t *testing.T) {
+	u := newTestUser(t)
+	project, err := u.CreateProject("Board", "")
+	if err != nil {
+		t.Fatalf("CreateProject: %v", err)
+	}
+	wf := DefaultWorkflow()
+	wf.Column(StatusInProgress).WIPLimit = 1
+	if _, err = u.SetWorkflow(project.ID, wf); err != nil {
+		t.Fatalf("SetWorkflow: %v", err)
+	}
+	newTodo := func(content string, parentID *int) (Todo, error) {
+		return u.CreateTodo(Todo{Content: content, Status: StatusInProgress, ProjectID: &project.ID, ParentID: parentID})
+	}
+
+	first, err := newTodo("first", nil)
+	if err != nil {
+		t.Fatalf("first CreateTodo: %v", err)
+	}
+	if first.Status != StatusInProgress {
+		t.Errorf("status = %s, want %s", first.Status, StatusInProgress)
+	}
+
+	var verr *ValidationError
+	if _, err = newTodo("second", nil); !errors.As(err, &verr) || verr.Fields["status"] == "" {
+		t.Errorf("second CreateTodo err = %v, want a status validation error", err)
+	}
+	// Subtasks do not count towards the limit, until they are promoted.
+	subtask, err := newTodo("subtask", &first.ID)
+	if err != nil {
+		t.Fatalf("subtask CreateTodo: %v", err)
+	}
+	topLevel := 0
+	if _, err = u.PatchTodo(subtask.ID, TodoPatch{ParentID: &topLevel}); !errors.As(err, &verr) || verr.Fields["status"] == "" {
+		t.Errorf("promoting a subtask into a full column err = %v, want a status validation error", err)
+	}
+	subtask.ParentID = nil
+	if _, err = u.ReplaceTodo(subtask); !errors.As(err, &verr) || verr.Fields["status"] == "" {
+		t.Errorf("replacing a subtask with a top-level todo in a full column err = %v, want a status validation error", err)
+	}
+
+	waiting, err := u.CreateTodo(Todo{Content: "waiting", ProjectID: &project.ID})
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	status := StatusInProgress
+	if _, err = u.PatchTodo(waiting.ID, TodoPatch{Status: &status}); !errors.As(err, &verr) {
+		t.Errorf("PatchTodo into a full column err = %v, want a validation error", err)
+	}
+}
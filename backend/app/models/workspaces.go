@@ -0,0 +1,635 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const MaxWorkspaceNameLength = 100
+
+// ErrForbidden is returned when a workspace member's role does not allow
+// an action. Controllers turn it into a 403.
+var ErrForbidden = errors.New("forbidden")
+
+// Role is what a member may do in a workspace. Each role includes the
+// rights of the ones below it.
+type Role string
+
+const (
+	RoleOwner  Role = "owner"  // manages members and invitations, deletes the workspace
+	RoleEditor Role = "editor" // creates and changes todos, projects and tags
+	RoleViewer Role = "viewer" // reads only
+)
+
+func (r Role) level() int {
+	switch r {
+	case RoleOwner:
+		return 3
+	case RoleEditor:
+		return 2
+	case RoleViewer:
+		return 1
+	}
+	return 0
+}
+
+// Covers reports whether r includes the rights of need.
+func (r Role) Covers(need Role) bool {
+	return r.level() >= need.level()
+}
+
+// Assignable reports whether members can be given r. Every workspace has
+// exactly one owner, the user who created it.
+func (r Role) Assignable() bool {
+	return r == RoleEditor || r == RoleViewer
+}
+
+type InvitationStatus string
+
+const (
+	InvitationPending  InvitationStatus = "pending"
+	InvitationAccepted InvitationStatus = "accepted"
+	InvitationDeclined InvitationStatus = "declined"
+	InvitationRevoked  InvitationStatus = "revoked"
+)
+
+// Workspace is a shared space whose todos, projects and tags belong to all
+// of its members. They are stored under the owner's user id with the
+// workspace's id in workspace_id; rows without one are personal. Members
+// read and enter due dates in their own time zone, and their uploads count
+// against their own attachment quota.
+type Workspace struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	OwnerID     int       `json:"owner_id"`
+	Role        Role      `json:"role"` // of the user asking
+	MemberCount int       `json:"member_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type WorkspaceMember struct {
+	UserID   int       `json:"user_id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Role     Role      `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Invitation asks the user who had an email address when they were invited
+// to join a workspace with a role. Invitations to addresses without an
+// account look the same to the owner but can never be accepted.
+type Invitation struct {
+	ID            int              `json:"id"`
+	WorkspaceID   int              `json:"workspace_id"`
+	WorkspaceName string           `json:"workspace_name"`
+	UserID        int              `json:"-"` // the invitee, 0 when no account had the address
+	Email         string           `json:"email"`
+	InviterID     int              `json:"inviter_id"`
+	InviterName   string           `json:"inviter_name"`
+	Role          Role             `json:"role"`
+	Status        InvitationStatus `json:"status"`
+	CreatedAt     time.Time        `json:"created_at"`
+	RespondedAt   *time.Time       `json:"responded_at"`
+}
+
+func validateWorkspaceName(name string) error {
+	v := &ValidationError{}
+	if name == "" {
+		v.Add("name", "must not be empty")
+	} else if utf8.RuneCountInString(name) > MaxWorkspaceNameLength {
+		v.Add("name", fmt.Sprintf("must be at most %d characters", MaxWorkspaceNameLength))
+	}
+	return v.Err()
+}
+
+// actor is the user making a change: the member for a workspace scope,
+// otherwise the user itself.
+func (u *User) actor() int {
+	if u.actorID != 0 {
+		return u.actorID
+	}
+	return u.ID
+}
+
+// WorkspaceID is the workspace the user acts on, 0 for the personal space.
+func (u *User) WorkspaceID() int {
+	return u.workspaceID
+}
+
+// workspaceRef is u.workspaceID as stored in a workspace_id column.
+func (u *User) workspaceRef() *int {
+	if u.workspaceID == 0 {
+		return nil
+	}
+	id := u.workspaceID
+	return &id
+}
+
+// inWorkspace restricts table to the rows of one workspace, or to personal
+// rows for 0. Its argument is the workspace id.
+func inWorkspace(table string) string {
+	return `COALESCE(` + table + `.workspace_id, 0) = ?`
+}
+
+// WorkspaceScope returns the user to act as on workspace id, 0 being the
+// user's personal space. Workspaces the user is not a member of are
+// reported as sql.ErrNoRows, a role below need as ErrForbidden.
+func (u *User) WorkspaceScope(id int, need Role) (scope User, err error) {
+	if id == 0 {
+		return *u, nil
+	}
+	var ownerID int
+	var role Role
+	cmd := `SELECT workspaces.owner_id, workspace_members.role FROM workspaces
+	JOIN workspace_members ON workspace_members.workspace_id = workspaces.id
+	WHERE workspaces.id = ? AND workspace_members.user_id = ?`
+	if err = Db.QueryRow(cmd, id, u.ID).Scan(&ownerID, &role); err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("WorkspaceScope error:", err)
+		}
+		return scope, err
+	}
+	if !role.Covers(need) {
+		return scope, ErrForbidden
+	}
+	return User{ID: ownerID, workspaceID: id, actorID: u.ID}, nil
+}
+
+// rowScope is WorkspaceScope for the workspace a todo, project or tag
+// belongs to, trashed todos included.
+func (u *User) rowScope(table string, id int, need Role) (scope User, err error) {
+	var ownerID, workspaceID int
+	cmd := `SELECT user_id, COALESCE(workspace_id, 0) FROM ` + table + ` WHERE id = ?`
+	if err = Db.QueryRow(cmd, id).Scan(&ownerID, &workspaceID); err != nil {
+		return scope, err
+	}
+	if workspaceID == 0 {
+		if ownerID != u.ID {
+			return scope, sql.ErrNoRows
+		}
+		return *u, nil
+	}
+	return u.WorkspaceScope(workspaceID, need)
+}
+
+// TodoScope returns the user to act as on todo id, see WorkspaceScope.
+func (u *User) TodoScope(id int, need Role) (User, error) {
+	return u.rowScope("todos", id, need)
+}
+
+func (u *User) ProjectScope(id int, need Role) (User, error) {
+	return u.rowScope("projects", id, need)
+}
+
+func (u *User) TagScope(id int, need Role) (User, error) {
+	return u.rowScope("tags", id, need)
+}
+
+// linkScope is TodoScope for the todo a comment or attachment is on.
+func (u *User) linkScope(table string, id int, need Role) (scope User, err error) {
+	var todoID int
+	if err = Db.QueryRow(`SELECT todo_id FROM `+table+` WHERE id = ?`, id).Scan(&todoID); err != nil {
+		return scope, err
+	}
+	return u.TodoScope(todoID, need)
+}
+
+func (u *User) CommentScope(id int, need Role) (User, error) {
+	return u.linkScope("comments", id, need)
+}
+
+func (u *User) AttachmentScope(id int, need Role) (User, error) {
+	return u.linkScope("attachments", id, need)
+}
+
+const workspaceColumns = `workspaces.id, workspaces.name, workspaces.owner_id, workspace_members.role,
+		(SELECT COUNT(*) FROM workspace_members m WHERE m.workspace_id = workspaces.id),
+		workspaces.created_at`
+
+const workspaceTables = `workspaces
+		JOIN workspace_members ON workspace_members.workspace_id = workspaces.id AND workspace_members.user_id = ?`
+
+func workspaceFields(w *Workspace) []interface{} {
+	return []interface{}{&w.ID, &w.Name, &w.OwnerID, &w.Role, &w.MemberCount, &w.CreatedAt}
+}
+
+// CreateWorkspace creates a workspace with the user as its owner.
+func (u *User) CreateWorkspace(name string) (workspace Workspace, err error) {
+	name = strings.TrimSpace(name)
+	if err = validateWorkspaceName(name); err != nil {
+		return workspace, err
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return workspace, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	result, err := tx.Exec(`INSERT INTO workspaces (name, owner_id, created_at) VALUES (?, ?, ?)`, name, u.ID, now)
+	if err != nil {
+		log.Println("CreateWorkspace error:", err)
+		return workspace, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return workspace, err
+	}
+	cmd := `INSERT INTO workspace_members (workspace_id, user_id, role, created_at) VALUES (?, ?, ?, ?)`
+	if _, err = tx.Exec(cmd, id, u.ID, RoleOwner, now); err != nil {
+		log.Println("CreateWorkspace error:", err)
+		return workspace, err
+	}
+	if err = tx.Commit(); err != nil {
+		return workspace, err
+	}
+	return u.GetWorkspace(int(id))
+}
+
+// GetWorkspace returns a workspace the user is a member of, or
+// sql.ErrNoRows.
+func (u *User) GetWorkspace(id int) (workspace Workspace, err error) {
+	cmd := `SELECT ` + workspaceColumns + ` FROM ` + workspaceTables + ` WHERE workspaces.id = ?`
+	err = Db.QueryRow(cmd, u.ID, id).Scan(workspaceFields(&workspace)...)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("GetWorkspace error:", err)
+	}
+	return workspace, err
+}
+
+// GetWorkspaces lists the workspaces the user is a member of.
+func (u *User) GetWorkspaces() (workspaces []Workspace, err error) {
+	cmd := `SELECT ` + workspaceColumns + ` FROM ` + workspaceTables + ` ORDER BY lower(workspaces.name), workspaces.id`
+	rows, err := Db.Query(cmd, u.ID)
+	if err != nil {
+		log.Println("GetWorkspaces error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var workspace Workspace
+		if err = rows.Scan(workspaceFields(&workspace)...); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+// RenameWorkspace needs the owner role.
+func (u *User) RenameWorkspace(id int, name string) (workspace Workspace, err error) {
+	if _, err = u.WorkspaceScope(id, RoleOwner); err != nil {
+		return workspace, err
+	}
+	name = strings.TrimSpace(name)
+	if err = validateWorkspaceName(name); err != nil {
+		return workspace, err
+	}
+	if _, err = Db.Exec(`UPDATE workspaces SET name = ? WHERE id = ?`, name, id); err != nil {
+		log.Println("RenameWorkspace error:", err)
+		return workspace, err
+	}
+	return u.GetWorkspace(id)
+}
+
+// DeleteWorkspace needs the owner role. The workspace's todos, projects and
+// tags move to the owner's personal space rather than being deleted;
+// projects and tags whose names are taken there get the workspace name
+// appended. Reminders other members set on its todos are removed.
+func (u *User) DeleteWorkspace(id int) error {
+	workspace, err := u.GetWorkspace(id)
+	if err != nil {
+		return err
+	}
+	if !workspace.Role.Covers(RoleOwner) {
+		return ErrForbidden
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	suffix := " (" + workspace.Name + ")"
+	for _, table := range []string{"projects", "tags"} {
+		cmd := fmt.Sprintf(`UPDATE %[1]s SET name = name || ? WHERE workspace_id = ? AND EXISTS (
+			SELECT 1 FROM %[1]s personal WHERE personal.user_id = %[1]s.user_id
+				AND personal.workspace_id IS NULL AND lower(personal.name) = lower(%[1]s.name))`, table)
+		if _, err = tx.Exec(cmd, suffix, id); err != nil {
+			log.Println("DeleteWorkspace error:", err)
+			return err
+		}
+	}
+	err = execArgs(tx,
+		[]interface{}{`DELETE FROM reminders WHERE user_id != ? AND todo_id IN (SELECT id FROM todos WHERE workspace_id = ?)`, workspace.OwnerID, id},
+		[]interface{}{`UPDATE todos SET workspace_id = NULL WHERE workspace_id = ?`, id},
+		[]interface{}{`UPDATE projects SET workspace_id = NULL WHERE workspace_id = ?`, id},
+		[]interface{}{`UPDATE tags SET workspace_id = NULL WHERE workspace_id = ?`, id},
+		[]interface{}{`DELETE FROM workspace_invitations WHERE workspace_id = ?`, id},
+		[]interface{}{`DELETE FROM workspace_members WHERE workspace_id = ?`, id},
+		[]interface{}{`DELETE FROM workspaces WHERE id = ?`, id},
+	)
+	if err != nil {
+		log.Println("DeleteWorkspace error:", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// execArgs runs each statement, given as the SQL followed by its
+// arguments, in tx.
+func execArgs(tx *sql.Tx, stmts ...[]interface{}) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt[0].(string), stmt[1:]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMembers lists the members of a workspace the user belongs to, the
+// owner first.
+func (u *User) GetMembers(workspaceID int) (members []WorkspaceMember, err error) {
+	if _, err = u.WorkspaceScope(workspaceID, RoleViewer); err != nil {
+		return nil, err
+	}
+	cmd := `SELECT users.id, users.name, users.email, workspace_members.role, workspace_members.created_at
+	FROM workspace_members JOIN users ON users.id = workspace_members.user_id
+	WHERE workspace_members.workspace_id = ?
+	ORDER BY workspace_members.role != ?, lower(users.name), users.id`
+	rows, err := Db.Query(cmd, workspaceID, RoleOwner)
+	if err != nil {
+		log.Println("GetMembers error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m WorkspaceMember
+		if err = rows.Scan(&m.UserID, &m.Name, &m.Email, &m.Role, &m.JoinedAt); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (u *User) getMember(workspaceID, userID int) (m WorkspaceMember, err error) {
+	cmd := `SELECT users.id, users.name, users.email, workspace_members.role, workspace_members.created_at
+	FROM workspace_members JOIN users ON users.id = workspace_members.user_id
+	WHERE workspace_members.workspace_id = ? AND workspace_members.user_id = ?`
+	err = Db.QueryRow(cmd, workspaceID, userID).Scan(&m.UserID, &m.Name, &m.Email, &m.Role, &m.JoinedAt)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("getMember error:", err)
+	}
+	return m, err
+}
+
+// SetMemberRole changes the role of a member other than the owner. It
+// needs the owner role.
+func (u *User) SetMemberRole(workspaceID, userID int, role Role) (m WorkspaceMember, err error) {
+	if _, err = u.WorkspaceScope(workspaceID, RoleOwner); err != nil {
+		return m, err
+	}
+	if !role.Assignable() {
+		return m, &ValidationError{Fields: map[string]string{"role": `must be "editor" or "viewer"`}}
+	}
+	if m, err = u.getMember(workspaceID, userID); err != nil {
+		return m, err
+	}
+	if m.Role == RoleOwner {
+		return m, &ValidationError{Fields: map[string]string{"role": "the owner's role cannot be changed"}}
+	}
+	cmd := `UPDATE workspace_members SET role = ? WHERE workspace_id = ? AND user_id = ?`
+	if _, err = Db.Exec(cmd, role, workspaceID, userID); err != nil {
+		log.Println("SetMemberRole error:", err)
+		return m, err
+	}
+	m.Role = role
+	return m, nil
+}
+
+// RemoveMember takes a member out of a workspace, which needs the owner
+// role unless members remove themselves. The owner cannot leave; delete
+// the workspace instead. The member's reminders on its todos go with them.
+func (u *User) RemoveMember(workspaceID, userID int) error {
+	need := RoleOwner
+	if userID == u.ID {
+		need = RoleViewer
+	}
+	if _, err := u.WorkspaceScope(workspaceID, need); err != nil {
+		return err
+	}
+	m, err := u.getMember(workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if m.Role == RoleOwner {
+		return &ValidationError{Fields: map[string]string{"userId": "the owner cannot leave the workspace"}}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = execArgs(tx,
+		[]interface{}{`DELETE FROM reminders WHERE user_id = ? AND todo_id IN (SELECT id FROM todos WHERE workspace_id = ?)`, userID, workspaceID},
+		[]interface{}{`DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?`, workspaceID, userID},
+	)
+	if err != nil {
+		log.Println("RemoveMember error:", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+const invitationColumns = `workspace_invitations.id, workspace_invitations.workspace_id, workspaces.name,
+		workspace_invitations.user_id, workspace_invitations.email, workspace_invitations.inviter_id, COALESCE(inviter.name, ''),
+		workspace_invitations.role, workspace_invitations.status,
+		workspace_invitations.created_at, workspace_invitations.responded_at`
+
+const invitationTables = `workspace_invitations
+		JOIN workspaces ON workspaces.id = workspace_invitations.workspace_id
+		LEFT JOIN users inviter ON inviter.id = workspace_invitations.inviter_id`
+
+func invitationFields(i *Invitation) []interface{} {
+	return []interface{}{&i.ID, &i.WorkspaceID, &i.WorkspaceName, &i.UserID, &i.Email, &i.InviterID, &i.InviterName,
+		&i.Role, &i.Status, &i.CreatedAt, &i.RespondedAt}
+}
+
+func queryInvitations(cmd string, args ...interface{}) (invitations []Invitation, err error) {
+	rows, err := Db.Query(cmd, args...)
+	if err != nil {
+		log.Println("queryInvitations error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i Invitation
+		if err = rows.Scan(invitationFields(&i)...); err != nil {
+			log.Println("Scan error:", err)
+			continue
+		}
+		invitations = append(invitations, i)
+	}
+	return invitations, rows.Err()
+}
+
+func getInvitation(id int) (i Invitation, err error) {
+	cmd := `SELECT ` + invitationColumns + ` FROM ` + invitationTables + ` WHERE workspace_invitations.id = ?`
+	err = Db.QueryRow(cmd, id).Scan(invitationFields(&i)...)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("getInvitation error:", err)
+	}
+	return i, err
+}
+
+// InviteMember invites the user with the given email address to a
+// workspace. It needs the owner role. The invitation is bound to the account
+// that has the address now, so signing up with it later does not let anyone
+// accept. It is created the same way whether or not there is such an
+// account, so owners cannot use it to find out who is registered.
+func (u *User) InviteMember(workspaceID int, email string, role Role) (i Invitation, err error) {
+	if _, err = u.WorkspaceScope(workspaceID, RoleOwner); err != nil {
+		return i, err
+	}
+	v := &ValidationError{}
+	if !role.Assignable() {
+		v.Add("role", `must be "editor" or "viewer"`)
+	}
+	email = strings.TrimSpace(email)
+	var inviteeID int
+	if email == "" {
+		v.Add("email", "must not be empty")
+	} else {
+		// Emails are not unique, so the oldest account with the address
+		// is the one invited.
+		cmd := `SELECT id FROM users WHERE email = ? ORDER BY id LIMIT 1`
+		if err = Db.QueryRow(cmd, email).Scan(&inviteeID); err != nil && err != sql.ErrNoRows {
+			log.Println("InviteMember error:", err)
+			return i, err
+		}
+		if inviteeID != 0 {
+			if _, err = u.getMember(workspaceID, inviteeID); err == nil {
+				v.Add("email", "is already a member")
+			} else if err != sql.ErrNoRows {
+				return i, err
+			}
+		}
+		var pending int
+		cmd = `SELECT COUNT(*) FROM workspace_invitations WHERE workspace_id = ? AND email = ? AND status = ?`
+		if err = Db.QueryRow(cmd, workspaceID, email, InvitationPending).Scan(&pending); err != nil {
+			log.Println("InviteMember error:", err)
+			return i, err
+		}
+		if pending > 0 {
+			v.Add("email", "already has a pending invitation")
+		}
+	}
+	if err = v.Err(); err != nil {
+		return i, err
+	}
+
+	cmd := `INSERT INTO workspace_invitations (workspace_id, user_id, email, inviter_id, role, status, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := Db.Exec(cmd, workspaceID, inviteeID, email, u.ID, role, InvitationPending, time.Now().UTC())
+	if err != nil {
+		log.Println("InviteMember error:", err)
+		return i, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return i, err
+	}
+	return getInvitation(int(id))
+}
+
+// GetWorkspaceInvitations lists a workspace's pending invitations. It needs
+// the owner role.
+func (u *User) GetWorkspaceInvitations(workspaceID int) ([]Invitation, error) {
+	if _, err := u.WorkspaceScope(workspaceID, RoleOwner); err != nil {
+		return nil, err
+	}
+	cmd := `SELECT ` + invitationColumns + ` FROM ` + invitationTables + `
+	WHERE workspace_invitations.workspace_id = ? AND workspace_invitations.status = ?
+	ORDER BY workspace_invitations.id`
+	return queryInvitations(cmd, workspaceID, InvitationPending)
+}
+
+// GetInvitations lists the pending invitations sent to the user.
+func (u *User) GetInvitations() ([]Invitation, error) {
+	cmd := `SELECT ` + invitationColumns + ` FROM ` + invitationTables + `
+	WHERE workspace_invitations.user_id = ? AND workspace_invitations.status = ?
+	ORDER BY workspace_invitations.id`
+	return queryInvitations(cmd, u.ID, InvitationPending)
+}
+
+// RespondToInvitation accepts or declines one of the user's pending
+// invitations. Accepting makes the user a member with the invited role.
+func (u *User) RespondToInvitation(id int, accept bool) (i Invitation, err error) {
+	if i, err = getInvitation(id); err != nil {
+		return i, err
+	}
+	if i.UserID != u.ID || i.Status != InvitationPending {
+		return i, sql.ErrNoRows
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return i, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	status := InvitationDeclined
+	if accept {
+		status = InvitationAccepted
+		cmd := `INSERT OR IGNORE INTO workspace_members (workspace_id, user_id, role, created_at) VALUES (?, ?, ?, ?)`
+		if _, err = tx.Exec(cmd, i.WorkspaceID, u.ID, i.Role, now); err != nil {
+			log.Println("RespondToInvitation error:", err)
+			return i, err
+		}
+	}
+	cmd := `UPDATE workspace_invitations SET status = ?, responded_at = ? WHERE id = ? AND status = ?`
+	if _, err = tx.Exec(cmd, status, now, id, InvitationPending); err != nil {
+		log.Println("RespondToInvitation error:", err)
+		return i, err
+	}
+	if err = tx.Commit(); err != nil {
+		return i, err
+	}
+	return getInvitation(id)
+}
+
+// RevokeInvitation withdraws a pending invitation. It needs the owner role
+// in the invitation's workspace.
+func (u *User) RevokeInvitation(id int) error {
+	i, err := getInvitation(id)
+	if err != nil {
+		return err
+	}
+	if _, err = u.WorkspaceScope(i.WorkspaceID, RoleOwner); err != nil {
+		return err
+	}
+	if i.Status != InvitationPending {
+		return sql.ErrNoRows
+	}
+	cmd := `UPDATE workspace_invitations SET status = ?, responded_at = ? WHERE id = ? AND status = ?`
+	if _, err = Db.Exec(cmd, InvitationRevoked, time.Now().UTC(), id, InvitationPending); err != nil {
+		log.Println("RevokeInvitation error:", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"todo_app/config"
+)
+
+func TestWorkspaceRoles(t *testing.T) {
+	owner, editor, viewer, outsider := newTestUser(t), newTestUser(t), newTestUser(t), newTestUser(t)
+	ws := newTestWorkspace(t, owner, map[*User]Role{&editor: RoleEditor, &viewer: RoleViewer})
+	todo := newTestTodo(t, workspaceScope(t, owner, ws.ID), "shared")
+	personal := newTestTodo(t, owner, "personal")
+
+	tests := []struct {
+		name string
+		user User
+		id   int
+		need Role
+		want error
+	}{
+		{"editor edits", editor, todo.ID, RoleEditor, nil},
+		{"viewer reads", viewer, todo.ID, RoleViewer, nil},
+		{"viewer edits", viewer, todo.ID, RoleEditor, ErrForbidden},
+		{"outsider reads", outsider, todo.ID, RoleViewer, sql.ErrNoRows},
+		{"member reads owner's personal todo", editor, personal.ID, RoleViewer, sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, err := tt.user.TodoScope(tt.id, tt.need)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("TodoScope err = %v, want %v", err, tt.want)
+			}
+			if err == nil {
+				if _, err = scope.GetTodo(tt.id); err != nil {
+					t.Errorf("GetTodo through scope: %v", err)
+				}
+			}
+		})
+	}
+
+	if _, err := viewer.WorkspaceScope(ws.ID, RoleEditor); !errors.Is(err, ErrForbidden) {
+		t.Errorf("viewer creating in workspace err = %v, want ErrForbidden", err)
+	}
+	if _, err := editor.InviteMember(ws.ID, outsider.Email, RoleViewer); !errors.Is(err, ErrForbidden) {
+		t.Errorf("editor inviting err = %v, want ErrForbidden", err)
+	}
+	if err := editor.DeleteWorkspace(ws.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("editor deleting workspace err = %v, want ErrForbidden", err)
+	}
+}
+
+func TestWorkspaceDueDatesInMemberTimeZone(t *testing.T) {
+	owner, member := newTestUser(t), newTestUser(t)
+	if err := owner.UpdateTimeZone("America/New_York"); err != nil {
+		t.Fatalf("UpdateTimeZone: %v", err)
+	}
+	if err := member.UpdateTimeZone("Asia/Tokyo"); err != nil {
+		t.Fatalf("UpdateTimeZone: %v", err)
+	}
+	ws := newTestWorkspace(t, owner, map[*User]Role{&member: RoleEditor})
+	ownerScope, memberScope := workspaceScope(t, owner, ws.ID), workspaceScope(t, member, ws.ID)
+
+	// 12:00 in New York is 02:00 the next day in Tokyo.
+	todo, err := ownerScope.CreateTodo(Todo{Content: "call", DueDate: "2030-01-15", DueTime: "12:00"})
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	seen, err := memberScope.GetTodo(todo.ID)
+	if err != nil {
+		t.Fatalf("GetTodo: %v", err)
+	}
+	if seen.DueDate != "2030-01-16" || seen.DueTime != "02:00" || !seen.DueAt.Equal(*todo.DueAt) {
+		t.Errorf("member sees due %s %s (%v), want 2030-01-16 02:00 (%v)", seen.DueDate, seen.DueTime, seen.DueAt, todo.DueAt)
+	}
+
+	// What the member enters is read in Tokyo time and stored for the owner.
+	entered, err := memberScope.CreateTodo(Todo{Content: "reply", DueDate: "2030-01-16", DueTime: "02:00"})
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	if entered.DueDate != "2030-01-16" || entered.DueTime != "02:00" || !entered.DueAt.Equal(*todo.DueAt) {
+		t.Errorf("member created due %s %s (%v), want 2030-01-16 02:00 (%v)", entered.DueDate, entered.DueTime, entered.DueAt, todo.DueAt)
+	}
+	var stored time.Time
+	if err = Db.QueryRow(`SELECT due_date FROM todos WHERE id = ?`, entered.ID).Scan(&stored); err != nil {
+		t.Fatalf("reading due_date: %v", err)
+	}
+	if got := stored.Format(DueDateLayout); got != "2030-01-15" {
+		t.Errorf("stored due_date = %s, want the owner's 2030-01-15", got)
+	}
+
+	// An all-day todo without a date is due today where the member is.
+	allDay, err := memberScope.CreateTodo(Todo{Content: "today"})
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	if want := today(member.Location()); allDay.DueDate != want || !allDay.DueToday {
+		t.Errorf("all-day todo due %s (today %v), want %s", allDay.DueDate, allDay.DueToday, want)
+	}
+}
+
+func TestWorkspaceAttachmentsUseUploaderQuota(t *testing.T) {
+	ConfigureAttachments(&LocalBlobStore{Dir: filepath.Join(config.Config.AttachmentDir, t.Name())}, AttachmentLimits{MaxSize: 100, UserQuota: 10})
+	defer ConfigureAttachments(nil, AttachmentLimits{})
+
+	owner, member := newTestUser(t), newTestUser(t)
+	ws := newTestWorkspace(t, owner, map[*User]Role{&member: RoleEditor})
+	ownerScope, memberScope := workspaceScope(t, owner, ws.ID), workspaceScope(t, member, ws.ID)
+	todo := newTestTodo(t, ownerScope, "shared")
+
+	a, err := memberScope.CreateAttachment(todo.ID, "notes.txt", strings.NewReader("12345678"))
+	if err != nil {
+		t.Fatalf("CreateAttachment: %v", err)
+	}
+	if a.UserID != member.ID {
+		t.Errorf("attachment user_id = %d, want the uploader %d", a.UserID, member.ID)
+	}
+	for _, u := range []struct {
+		name  string
+		scope User
+		want  int64
+	}{{"member", memberScope, 8}, {"owner", ownerScope, 0}} {
+		if used, err := u.scope.AttachmentUsage(); err != nil || used != u.want {
+			t.Errorf("%s usage = %d, %v, want %d", u.name, used, err, u.want)
+		}
+	}
+	if attachments, err := ownerScope.GetAttachments(todo.ID); err != nil || len(attachments) != 1 {
+		t.Errorf("owner lists %d attachments, %v, want the member's upload", len(attachments), err)
+	}
+
+	var verr *ValidationError
+	if _, err = memberScope.CreateAttachment(todo.ID, "more.txt", strings.NewReader("12345")); !errors.As(err, &verr) {
+		t.Errorf("member over quota err = %v, want a validation error", err)
+	}
+	if _, err = ownerScope.CreateAttachment(todo.ID, "own.txt", strings.NewReader("12345678")); err != nil {
+		t.Errorf("owner upload within own quota: %v", err)
+	}
+}
+
+func TestInvitationsBindToExistingAccounts(t *testing.T) {
+	owner, member := newTestUser(t), newTestUser(t)
+	ws, err := owner.CreateWorkspace("Team")
+	if err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	newcomer := newTestEmail()
+
+	registered, err := owner.InviteMember(ws.ID, member.Email, RoleEditor)
+	if err != nil {
+		t.Fatalf("InviteMember registered: %v", err)
+	}
+	unregistered, err := owner.InviteMember(ws.ID, newcomer, RoleEditor)
+	if err != nil {
+		t.Fatalf("InviteMember unregistered: %v", err)
+	}
+	if registered.UserID != member.ID || unregistered.UserID != 0 {
+		t.Errorf("invitees = %d and %d, want %d and none", registered.UserID, unregistered.UserID, member.ID)
+	}
+	var verr *ValidationError
+	for _, email := range []string{member.Email, newcomer} {
+		if _, err = owner.InviteMember(ws.ID, email, RoleViewer); !errors.As(err, &verr) || verr.Fields["email"] != "already has a pending invitation" {
+			t.Errorf("second invitation to %s err = %v, want a pending invitation error", email, err)
+		}
+	}
+
+	// Signing up with an invited address, whether it already has an
+	// account or not, does not reach the invitation.
+	for _, invitation := range []Invitation{registered, unregistered} {
+		squatter := newTestUserWithEmail(t, invitation.Email)
+		if invitations, err := squatter.GetInvitations(); err != nil || len(invitations) != 0 {
+			t.Errorf("squatter on %s sees %+v, %v, want no invitations", invitation.Email, invitations, err)
+		}
+		if _, err = squatter.RespondToInvitation(invitation.ID, true); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("squatter on %s accepting err = %v, want sql.ErrNoRows", invitation.Email, err)
+		}
+	}
+
+	if _, err = member.RespondToInvitation(registered.ID, true); err != nil {
+		t.Fatalf("RespondToInvitation: %v", err)
+	}
+	if _, err = member.WorkspaceScope(ws.ID, RoleEditor); err != nil {
+		t.Errorf("WorkspaceScope after accepting: %v", err)
+	}
+	if _, err = owner.InviteMember(ws.ID, member.Email, RoleViewer); !errors.As(err, &verr) || verr.Fields["email"] != "is already a member" {
+		t.Errorf("inviting a member err = %v, want an already a member error", err)
+	}
+}
@@ -2,37 +2,130 @@ package config
 
 import (
 	"log"
-
-	"todo_app/utils"
+	"math"
+	"time"
 
 	"gopkg.in/go-ini/ini.v1"
 )
 
+// The highest argon2id costs that may be configured. Stored hashes asking
+// for more are rejected instead of being computed.
+const (
+	MaxArgon2Time    = 100
+	MaxArgon2Memory  = 4 * 1024 * 1024 // KiB
+	MaxArgon2Threads = math.MaxUint8
+)
+
 type ConfigList struct {
 	Port      string
 	SQLDriver string
 	DbName    string
 	LogFile   string
-}
 
-var Config ConfigList
+	Argon2Time    int
+	Argon2Memory  int
+	Argon2Threads int
+
+	SessionLifetime      time.Duration
+	SessionIdleTimeout   time.Duration
+	SessionSweepInterval time.Duration
+
+	ReminderPollInterval time.Duration
+	ReminderMaxAttempts  int
+
+	TrashRetention     time.Duration
+	TrashPurgeInterval time.Duration
 
-func init() {
-	LoadConfig()
-	utils.LoggingSettings(Config.LogFile)
+	AttachmentDir          string
+	AttachmentMaxSize      int64
+	AttachmentQuota        int64
+	AttachmentAllowedTypes []string
+
+	SMTPHost     string
+	SMTPPort     string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
 }
 
-func LoadConfig() {
-	log.Println("Loading config.ini...")
-	cfg, err := ini.Load("config.ini")
+var Config ConfigList
+
+// Load reads the settings from the ini file at path, stopping the program
+// when it is missing or holds unusable values.
+func Load(path string) {
+	log.Printf("Loading %s...", path)
+	cfg, err := ini.Load(path)
 	if err != nil {
-		log.Fatalf("Failed to load config.ini: %v", err)
+		log.Fatalf("Failed to load %s: %v", path, err)
 	}
+	loadFrom(cfg)
+	log.Printf("Config loaded - Port: %s, DB: %s", Config.Port, Config.DbName)
+}
+
+func loadFrom(cfg *ini.File) {
 	Config = ConfigList{
 		Port:      cfg.Section("web").Key("port").String(),
 		SQLDriver: cfg.Section("db").Key("driver").String(),
 		DbName:    cfg.Section("db").Key("name").String(),
 		LogFile:   cfg.Section("web").Key("logfile").String(),
+
+		Argon2Time:    cfg.Section("password").Key("argon2_time").MustInt(1),
+		Argon2Memory:  cfg.Section("password").Key("argon2_memory").MustInt(64 * 1024),
+		Argon2Threads: cfg.Section("password").Key("argon2_threads").MustInt(4),
+
+		SessionLifetime:      cfg.Section("session").Key("lifetime").MustDuration(30 * 24 * time.Hour),
+		SessionIdleTimeout:   cfg.Section("session").Key("idle_timeout").MustDuration(7 * 24 * time.Hour),
+		SessionSweepInterval: cfg.Section("session").Key("sweep_interval").MustDuration(10 * time.Minute),
+
+		ReminderPollInterval: cfg.Section("reminder").Key("poll_interval").MustDuration(30 * time.Second),
+		ReminderMaxAttempts:  cfg.Section("reminder").Key("max_attempts").MustInt(5),
+
+		TrashRetention:     cfg.Section("trash").Key("retention").MustDuration(30 * 24 * time.Hour),
+		TrashPurgeInterval: cfg.Section("trash").Key("purge_interval").MustDuration(time.Hour),
+
+		AttachmentDir:          cfg.Section("attachments").Key("dir").MustString("attachments"),
+		AttachmentMaxSize:      cfg.Section("attachments").Key("max_size").MustInt64(10 << 20),
+		AttachmentQuota:        cfg.Section("attachments").Key("quota").MustInt64(100 << 20),
+		AttachmentAllowedTypes: cfg.Section("attachments").Key("allowed_types").Strings(","),
+
+		SMTPHost:     cfg.Section("smtp").Key("host").String(),
+		SMTPPort:     cfg.Section("smtp").Key("port").MustString("25"),
+		SMTPFrom:     cfg.Section("smtp").Key("from").MustString("todo@localhost"),
+		SMTPUsername: cfg.Section("smtp").Key("username").String(),
+		SMTPPassword: cfg.Section("smtp").Key("password").String(),
+	}
+	validateArgon2()
+	requirePositive("session.sweep_interval", Config.SessionSweepInterval)
+	requirePositive("reminder.poll_interval", Config.ReminderPollInterval)
+	// A retention of 0 keeps trashed todos, so the purger never starts.
+	if Config.TrashRetention < 0 {
+		log.Fatalf("Invalid config: trash.retention must not be negative, got %s", Config.TrashRetention)
+	}
+	if Config.TrashRetention > 0 {
+		requirePositive("trash.purge_interval", Config.TrashPurgeInterval)
+	}
+}
+
+// validateArgon2 stops startup on cost parameters the hasher cannot use:
+// they are cast to the unsigned widths of argon2.IDKey, which panics on zero
+// time or threads, and out-of-range values would silently wrap.
+func validateArgon2() {
+	if Config.Argon2Time < 1 || Config.Argon2Time > MaxArgon2Time {
+		log.Fatalf("Invalid config: password.argon2_time must be between 1 and %d, got %d", MaxArgon2Time, Config.Argon2Time)
+	}
+	if Config.Argon2Threads < 1 || Config.Argon2Threads > MaxArgon2Threads {
+		log.Fatalf("Invalid config: password.argon2_threads must be between 1 and %d, got %d", MaxArgon2Threads, Config.Argon2Threads)
+	}
+	// argon2 needs at least 8 KiB per thread.
+	if Config.Argon2Memory < 8*Config.Argon2Threads || Config.Argon2Memory > MaxArgon2Memory {
+		log.Fatalf("Invalid config: password.argon2_memory must be between %d and %d KiB, got %d", 8*Config.Argon2Threads, MaxArgon2Memory, Config.Argon2Memory)
+	}
+}
+
+// requirePositive stops startup on a non-positive interval, which would make
+// time.NewTicker panic once the background job starts.
+func requirePositive(key string, d time.Duration) {
+	if d <= 0 {
+		log.Fatalf("Invalid config: %s must be a positive duration, got %s", key, d)
 	}
-	log.Printf("Config loaded - Port: %s, DB: %s", Config.Port, Config.DbName)
 }
@@ -5,7 +5,11 @@ go 1.24.3
 require (
 	github.com/google/uuid v1.6.0
 	github.com/mattn/go-sqlite3 v1.14.32
+	golang.org/x/crypto v0.41.0
 	gopkg.in/go-ini/ini.v1 v1.67.0
 )
 
-require github.com/stretchr/testify v1.11.1 // indirect
+require (
+	github.com/stretchr/testify v1.11.1 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+)
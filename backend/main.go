@@ -2,18 +2,37 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"todo_app/app/controllers"
 	"todo_app/app/models"
+	"todo_app/config"
+	"todo_app/utils"
 )
 
 func main() {
-	if models.Db == nil {
+	config.Load("config.ini")
+	utils.LoggingSettings(config.Config.LogFile)
+	models.ConfigurePasswordHasher(config.Config.Argon2Time, config.Config.Argon2Memory, config.Config.Argon2Threads)
+	if err := models.Open(); err != nil {
 		fmt.Println("Error: Database connection failed")
-		return
+		os.Exit(1)
 	}
 	fmt.Printf("Database connected: %v\n", models.Db)
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			fmt.Printf("Migration failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if _, err := models.MigrateUp(false); err != nil {
+		fmt.Printf("Migration failed: %v\n", err)
+		os.Exit(1)
+	}
+
 	fmt.Println("Starting server on port 8080...")
 	err := controllers.StartMainServer()
 	if err != nil {
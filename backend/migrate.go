@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"todo_app/app/models"
+)
+
+// runMigrate implements `migrate [up|down|status] [-dry-run] [-steps n]`.
+func runMigrate(args []string) error {
+	command := "up"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "run the migrations and roll them back")
+	steps := fs.Int("steps", 1, "number of migrations to revert with down")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		applied, err := models.MigrateUp(*dryRun)
+		if err != nil {
+			return err
+		}
+		printMigrations("Applied", applied, *dryRun)
+	case "down":
+		reverted, err := models.MigrateDown(*steps, *dryRun)
+		if err != nil {
+			return err
+		}
+		printMigrations("Reverted", reverted, *dryRun)
+	case "status":
+		statuses, err := models.GetMigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.Applied {
+				fmt.Printf("%4d  %-45s applied %s\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("%4d  %-45s pending\n", s.Version, s.Name)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down or status)", command)
+	}
+	return nil
+}
+
+func printMigrations(verb string, list []models.Migration, dryRun bool) {
+	if dryRun {
+		verb += " (dry run, rolled back)"
+	}
+	if len(list) == 0 {
+		fmt.Println("Nothing to do")
+		return
+	}
+	for _, m := range list {
+		fmt.Printf("%s %d %s\n", verb, m.Version, m.Name)
+	}
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RenderMarkdown converts a small, commonly used Markdown subset to HTML:
+// headings, paragraphs, emphasis, inline and fenced code, links, block
+// quotes, rules and lists. All source text is HTML-escaped before any markup
+// is generated and links are limited to http, https and mailto, so the
+// output is safe to insert into a page without further sanitising.
+func RenderMarkdown(src string) string {
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	var out strings.Builder
+	var para []string
+
+	flushPara := func() {
+		if len(para) > 0 {
+			out.WriteString("<p>" + renderInline(strings.Join(para, "\n")) + "</p>\n")
+			para = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			flushPara()
+
+		case strings.HasPrefix(trimmed, "```"):
+			flushPara()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			out.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+
+		case headingPattern.MatchString(trimmed):
+			flushPara()
+			m := headingPattern.FindStringSubmatch(trimmed)
+			level := string(rune('0' + len(m[1])))
+			out.WriteString("<h" + level + ">" + renderInline(m[2]) + "</h" + level + ">\n")
+
+		case rulePattern.MatchString(trimmed):
+			flushPara()
+			out.WriteString("<hr>\n")
+
+		case strings.HasPrefix(trimmed, ">"):
+			flushPara()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote = append(quote, strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">"), " "))
+			}
+			i--
+			out.WriteString("<blockquote>\n" + RenderMarkdown(strings.Join(quote, "\n")) + "</blockquote>\n")
+
+		case bulletPattern.MatchString(trimmed), orderedPattern.MatchString(trimmed):
+			flushPara()
+			pattern, tag := bulletPattern, "ul"
+			if orderedPattern.MatchString(trimmed) {
+				pattern, tag = orderedPattern, "ol"
+			}
+			out.WriteString("<" + tag + ">\n")
+			for ; i < len(lines) && pattern.MatchString(strings.TrimSpace(lines[i])); i++ {
+				item := pattern.FindStringSubmatch(strings.TrimSpace(lines[i]))[1]
+				out.WriteString("<li>" + renderInline(item) + "</li>\n")
+			}
+			i--
+			out.WriteString("</" + tag + ">\n")
+
+		default:
+			para = append(para, trimmed)
+		}
+	}
+	flushPara()
+	return out.String()
+}
+
+var (
+	headingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	rulePattern    = regexp.MustCompile(`^(\*\s*){3,}$|^(-\s*){3,}$|^(_\s*){3,}$`)
+	bulletPattern  = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	orderedPattern = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+
+	linkPattern   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	strongPattern = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	emPattern     = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+
+	// placeholderMark delimits a link placeholder; it is removed from the
+	// source first so text cannot forge one.
+	placeholderMark    = "\x00"
+	placeholderPattern = regexp.MustCompile("\x00[0-9]+\x00")
+)
+
+// renderInline handles code spans first so nothing inside them is
+// formatted, then escapes the rest and applies links and emphasis.
+func renderInline(s string) string {
+	var out strings.Builder
+	for {
+		start := strings.Index(s, "`")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(s[start+1:], "`")
+		if end < 0 {
+			break
+		}
+		out.WriteString(formatText(s[:start]))
+		out.WriteString("<code>" + html.EscapeString(s[start+1:start+1+end]) + "</code>")
+		s = s[start+1+end+1:]
+	}
+	out.WriteString(formatText(s))
+	return strings.ReplaceAll(out.String(), "\n", "<br>\n")
+}
+
+// formatText escapes s and applies links and emphasis. Links are swapped
+// for numbered placeholders while emphasis is applied, so markers inside a
+// URL such as foo_bar_baz never end up as tags in the href.
+func formatText(s string) string {
+	s = html.EscapeString(strings.ReplaceAll(s, placeholderMark, ""))
+	var links []string
+	s = linkPattern.ReplaceAllStringFunc(s, func(m string) string {
+		parts := linkPattern.FindStringSubmatch(m)
+		text := applyEmphasis(parts[1])
+		href := html.UnescapeString(parts[2])
+		if !safeLink(href) {
+			return text
+		}
+		links = append(links, `<a href="`+html.EscapeString(href)+`" rel="nofollow noopener noreferrer">`+text+`</a>`)
+		return placeholderMark + strconv.Itoa(len(links)-1) + placeholderMark
+	})
+	s = applyEmphasis(s)
+	return placeholderPattern.ReplaceAllStringFunc(s, func(m string) string {
+		i, _ := strconv.Atoi(strings.Trim(m, placeholderMark))
+		return links[i]
+	})
+}
+
+func applyEmphasis(s string) string {
+	s = strongPattern.ReplaceAllString(s, "<strong>$1$2</strong>")
+	return emPattern.ReplaceAllString(s, "<em>$1$2</em>")
+}
+
+func safeLink(href string) bool {
+	u, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return true
+	case "":
+		// Relative links are fine as long as they cannot be read as a
+		// scheme by a lenient browser.
+		return !strings.Contains(href, ":")
+	}
+	return false
+}
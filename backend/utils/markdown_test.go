@@ -0,0 +1,29 @@
+package utils
+
+import "testing"
+
+func TestRenderMarkdownInline(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"emphasis", "**bold** and *em* and _em_", "<p><strong>bold</strong> and <em>em</em> and <em>em</em></p>\n"},
+		{"underscores in href", "[x](http://a.com/foo_bar_baz)",
+			`<p><a href="http://a.com/foo_bar_baz" rel="nofollow noopener noreferrer">x</a></p>` + "\n"},
+		{"stars in href", "[x](http://a.com/*a*/**b**)",
+			`<p><a href="http://a.com/*a*/**b**" rel="nofollow noopener noreferrer">x</a></p>` + "\n"},
+		{"emphasis in link text", "[**x**](http://a.com/a_b_c)",
+			`<p><a href="http://a.com/a_b_c" rel="nofollow noopener noreferrer"><strong>x</strong></a></p>` + "\n"},
+		{"emphasis around link", "*see [x](http://a.com/a*b) now*",
+			`<p><em>see <a href="http://a.com/a*b" rel="nofollow noopener noreferrer">x</a> now</em></p>` + "\n"},
+		{"unsafe link", "[x](javascript:alert)", "<p>x</p>\n"},
+		{"forged placeholder", "a\x000\x00b", "<p>a0b</p>\n"},
+		{"escaped", "<b>&</b>", "<p>&lt;b&gt;&amp;&lt;/b&gt;</p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderMarkdown(tt.src); got != tt.want {
+				t.Errorf("RenderMarkdown(%q) =\n%q\nwant\n%q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,11 +3,31 @@ import { apiClient } from './api';
 export interface BackendTodo {
   ID: number;
   Content: string;
+  Description: string; // Markdown
   UserID: number;
+  WorkspaceID: number | null;
   Priority: string;   // "high", "medium", "low"
-  Status: string;     // "todo", "completed", "in_progress"
-  DueDate: string;    // YYYY-MM-DD形式の日付
+  Status: string;     // ワークフローの列キー（既定は "todo", "in_progress", "completed"）
+  Done: boolean;      // 完了扱いの列にあるかどうか
+  DueDate: string;    // YYYY-MM-DD形式の日付（ユーザーのタイムゾーン）
+  DueTime: string;    // HH:MM形式、終日の場合は空文字
+  DueAt: string | null; // UTCの期限日時、終日の場合は null
+  DueToday: boolean;
+  Overdue: boolean;
+  ProjectID: number | null;
+  ParentID: number | null;
+  Subtasks?: BackendTodo[];
+  Progress?: { Done: number; Total: number };
+  CommentCount: number;
   CreatedAt: string;
+  UpdatedAt: string;
+  CompletedAt: string | null; // 完了扱いの列に入った日時、未完了の場合は null
+}
+
+export interface BackendProject {
+  id: number;
+  name: string;
+  color: string;
 }
 
 export interface FrontendTask {
@@ -21,23 +41,17 @@ export interface FrontendTask {
   category: string;
 }
 
+// 最後に取得したプロジェクト名（ID -> 名前）
+let projectNames = new Map<number, string>();
+
 // バックエンドTodoをフロントエンドTaskに変換
-const convertTodoToTask = (todo: BackendTodo): FrontendTask => {
-  const content = todo.Content.toLowerCase();
-  let project = 'General';
-  
-  // プロジェクト/カテゴリを判定
-  if (content.includes('work') || content.includes('meeting') || content.includes('project')) {
-    project = 'Work';
-  } else if (content.includes('team') || content.includes('collaborate')) {
-    project = 'Team';
-  } else if (content.includes('personal') || content.includes('home') || content.includes('family')) {
-    project = 'Personal';
-  }
+const convertTodoToTask = (todo: BackendTodo, projects: Map<number, string> = projectNames): FrontendTask => {
+  // プロジェクトはバックエンドで管理（未設定の場合は General）
+  const project = (todo.ProjectID !== null && projects.get(todo.ProjectID)) || 'General';
 
   // ステータスの変換
   let status: 'In Progress' | 'Completed' | 'To Do' = 'To Do';
-  if (todo.Status === 'completed') {
+  if (todo.Done) {
     status = 'Completed';
   } else if (todo.Status === 'in_progress') {
     status = 'In Progress';
@@ -45,7 +59,7 @@ const convertTodoToTask = (todo: BackendTodo): FrontendTask => {
 
   // バックエンドから取得した値を使用（デフォルト値も設定）
   const priority = (todo.Priority as 'high' | 'medium' | 'low') || 'medium';
-  const dueDate = todo.DueDate || new Date().toISOString().split('T')[0];
+  const dueDate = todo.DueDate;
 
   return {
     id: todo.ID.toString(),
@@ -53,41 +67,60 @@ const convertTodoToTask = (todo: BackendTodo): FrontendTask => {
     project,
     status,
     dueDate,
+    description: todo.Description || undefined,
     priority,
     category: project
   };
 };
 
 // フロントエンドTaskをバックエンドTodo用に変換
+// 指定されたフィールドのみを送信する（サーバー側は部分更新）
 const convertTaskToTodo = (task: Partial<FrontendTask>) => {
+  const todo: { content?: string; description?: string; priority?: string; status?: string; dueDate?: string } = {};
+
+  if (task.name !== undefined) {
+    todo.content = task.name;
+  }
+  if (task.description !== undefined) {
+    todo.description = task.description;
+  }
+  if (task.priority !== undefined) {
+    todo.priority = task.priority;
+  }
   // ステータスの変換
-  let status = 'todo';
-  if (task.status === 'Completed') {
-    status = 'completed';
-  } else if (task.status === 'In Progress') {
-    status = 'in_progress';
+  if (task.status !== undefined) {
+    let status = 'todo';
+    if (task.status === 'Completed') {
+      status = 'completed';
+    } else if (task.status === 'In Progress') {
+      status = 'in_progress';
+    }
+    todo.status = status;
+  }
+  if (task.dueDate !== undefined) {
+    todo.dueDate = task.dueDate;
   }
 
-  return {
-    content: task.name || '',
-    priority: task.priority || 'medium',
-    status: status,
-    dueDate: task.dueDate || new Date().toISOString().split('T')[0]
-  };
+  return todo;
 };
 
 export const tasksApi = {
   // タスク一覧取得
   getTasks: async (): Promise<FrontendTask[]> => {
     try {
-      const response = await apiClient.get('/todos');
-      
+      const [response, projectsResponse] = await Promise.all([
+        apiClient.get('/todos?sort=rank'),
+        apiClient.get('/api/v1/projects'),
+      ]);
+
+      projectNames = new Map<number, string>(
+        (projectsResponse.projects || []).map((p: BackendProject) => [p.id, p.name])
+      );
+
       if (response.todos && Array.isArray(response.todos)) {
-        return response.todos.map(convertTodoToTask);
-      } else if (response.status === 'success' && response.todos) {
-        return response.todos.map(convertTodoToTask);
+        return response.todos.map((todo: BackendTodo) => convertTodoToTask(todo));
       }
-      
+
       return [];
     } catch (error) {
       console.error('Failed to fetch tasks:', error);
@@ -96,14 +129,17 @@ export const tasksApi = {
   },
 
   // タスク作成
-  createTask: async (taskData: { name: string; description?: string; priority?: string; category?: string; dueDate?: string }): Promise<void> => {
+  createTask: async (taskData: { name: string; description?: string; priority?: string; category?: string; dueDate?: string }): Promise<FrontendTask> => {
     try {
       const todoData = {
         content: taskData.name,
+        description: taskData.description || '',
         priority: taskData.priority || 'medium',
-        dueDate: taskData.dueDate || new Date().toISOString().split('T')[0]
+        // 未指定の場合はサーバー側でユーザーのタイムゾーンの今日になる
+        dueDate: taskData.dueDate || ''
       };
-      await apiClient.post('/todos/save', todoData);
+      const response = await apiClient.post('/todos/save', todoData);
+      return convertTodoToTask(response.todo);
     } catch (error) {
       console.error('Failed to create task:', error);
       throw new Error('Failed to create task. Please try again.');
@@ -111,10 +147,11 @@ export const tasksApi = {
   },
 
   // タスク更新
-  updateTask: async (taskId: string, taskData: Partial<FrontendTask>): Promise<void> => {
+  updateTask: async (taskId: string, taskData: Partial<FrontendTask>): Promise<FrontendTask> => {
     try {
       const todoData = convertTaskToTodo(taskData);
-      await apiClient.post(`/todos/update/${taskId}`, todoData);
+      const response = await apiClient.post(`/todos/update/${taskId}`, todoData);
+      return convertTodoToTask(response.todo);
     } catch (error) {
       console.error('Failed to update task:', error);
       throw new Error('Failed to update task. Please try again.');
@@ -129,5 +166,29 @@ export const tasksApi = {
       console.error('Failed to delete task:', error);
       throw new Error('Failed to delete task. Please try again.');
     }
+  },
+
+  // タスクを afterId の直後（null の場合は列の先頭）に移動（ドラッグ＆ドロップ用）
+  moveTask: async (taskId: string, afterId: string | null): Promise<FrontendTask> => {
+    try {
+      const todo = await apiClient.post(`/api/v1/todos/${taskId}/move`, {
+        afterId: afterId === null ? null : Number(afterId)
+      });
+      return convertTodoToTask(todo);
+    } catch (error) {
+      console.error('Failed to move task:', error);
+      throw new Error('Failed to move task. Please try again.');
+    }
+  },
+
+  // 削除したタスクをゴミ箱から復元（削除の取り消し）
+  restoreTask: async (taskId: string): Promise<FrontendTask> => {
+    try {
+      const todo = await apiClient.post(`/api/v1/trash/${taskId}/restore`, {});
+      return convertTodoToTask(todo);
+    } catch (error) {
+      console.error('Failed to restore task:', error);
+      throw new Error('Failed to restore task. Please try again.');
+    }
   }
 };
\ No newline at end of file
@@ -389,12 +389,12 @@ export const TodoDashboard = () => {
   const handleCreateTask = async (taskData: { name: string; priority: 'high' | 'medium' | 'low'; dueDate: string }) => {
     try {
       setError(null);
-      await tasksApi.createTask({
+      const created = await tasksApi.createTask({
         name: taskData.name,
         priority: taskData.priority,
         dueDate: taskData.dueDate
       });
-      await loadTasks(false);
+      setTasks(prevTasks => [...prevTasks, created]);
     } catch (error) {
       console.error('Failed to create task:', error);
       setError('Failed to create task. Please try again.');
@@ -405,12 +405,12 @@ export const TodoDashboard = () => {
   const handleEditTask = async (taskId: string, taskData: { name: string; priority: 'high' | 'medium' | 'low'; dueDate: string }) => {
     try {
       setError(null);
-      await tasksApi.updateTask(taskId, {
+      const updated = await tasksApi.updateTask(taskId, {
         name: taskData.name,
         priority: taskData.priority,
         dueDate: taskData.dueDate,
       });
-      await loadTasks(false);
+      setTasks(prevTasks => prevTasks.map(t => (t.id === taskId ? updated : t)));
     } catch (error) {
       console.error('Failed to update task:', error);
       setError('Failed to update task. Please try again.');
@@ -422,7 +422,7 @@ export const TodoDashboard = () => {
     try {
       setError(null);
       await tasksApi.deleteTask(taskId);
-      await loadTasks(false);
+      setTasks(prevTasks => prevTasks.filter(t => t.id !== taskId));
     } catch (error) {
       console.error('Failed to delete task:', error);
       setError('Failed to delete task. Please try again.');